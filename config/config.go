@@ -0,0 +1,61 @@
+package config
+
+import (
+	"bytes"
+	"encoding/json"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"github.com/pkg/errors"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/models"
+	"sigs.k8s.io/yaml"
+)
+
+// Load reads, parses and validates the snapshot configuration file. Files
+// with a .yaml or .yml extension are parsed as YAML, anything else as JSON.
+func Load(path string) (*models.SnapshotConfigs, error) {
+	content, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, errors.Wrap(err, "error reading snapshot config file")
+	}
+	return parseFile(path, content)
+}
+
+func parseFile(path string, content []byte) (*models.SnapshotConfigs, error) {
+	switch strings.ToLower(filepath.Ext(path)) {
+	case ".yaml", ".yml":
+		return ParseYAML(content)
+	default:
+		return Parse(content)
+	}
+}
+
+// ParseYAML parses and validates a YAML snapshot configuration. It is
+// converted to JSON first, so field names and validation are the same as for
+// JSON files.
+func ParseYAML(content []byte) (*models.SnapshotConfigs, error) {
+	jsonContent, err := yaml.YAMLToJSONStrict(content)
+	if err != nil {
+		return nil, errors.Wrap(err, "error converting snapshot config file from yaml")
+	}
+	return Parse(jsonContent)
+}
+
+// Parse parses and validates the contents of a snapshot configuration file.
+// Unknown fields are rejected, so that typos do not go unnoticed.
+func Parse(content []byte) (*models.SnapshotConfigs, error) {
+	snapshotConfigs := &models.SnapshotConfigs{}
+	dec := json.NewDecoder(bytes.NewReader(content))
+	dec.DisallowUnknownFields()
+	if err := dec.Decode(snapshotConfigs); err != nil {
+		return nil, errors.Wrap(err, "error unmarshalling snapshot config file")
+	}
+	if dec.More() {
+		return nil, errors.New("error unmarshalling snapshot config file: unexpected data after the top-level object")
+	}
+	if err := validate(snapshotConfigs); err != nil {
+		return nil, errors.Wrap(err, "invalid snapshot config")
+	}
+	return snapshotConfigs, nil
+}
@@ -0,0 +1,93 @@
+package config
+
+import (
+	"testing"
+
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseValid(t *testing.T) {
+	sc, err := Parse([]byte(`{
+  "Descriptions": [
+    {
+      "retentionPeriodHours": 2,
+      "intervalSeconds": 100,
+      "description": {"key": "kubernetes.io/created-for/pvc/name", "value": "some-app-pd-pvc"}
+    }
+  ],
+  "Labels": [
+    {
+      "schedule": {"cron": "30 2 * * *", "timezone": "Europe/London"},
+      "retention": {"daily": 14},
+      "label": {"key": "name", "value": "some-app-name"}
+    }
+  ]
+}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, sc.Descriptions, 1)
+	assert.Len(t, sc.Labels, 1)
+	assert.Equal(t, 14, sc.Labels[0].Retention.Daily)
+}
+
+func TestParseRejectsUnknownFields(t *testing.T) {
+	_, err := Parse([]byte(`{"Label": []}`))
+	assert.EqualError(t, err, `error unmarshalling snapshot config file: json: unknown field "Label"`)
+}
+
+func TestParseReportsEveryProblem(t *testing.T) {
+	_, err := Parse([]byte(`{
+  "Labels": [
+    {"intervalSeconds": 0, "retentionPeriodHours": 2, "label": {"key": "name", "value": "app"}},
+    {"intervalSeconds": 60, "retentionPeriodHours": 0}
+  ],
+  "Descriptions": [
+    {"schedule": {"cron": "* *"}, "retention": {}, "description": {"key": "pvc"}},
+    {"schedule": {"cron": "@every 1h"}, "retention": {"daily": 7}, "description": {"key": "pvc"}}
+  ]
+}`))
+
+	var validationErr *ValidationError
+	if !errors.As(err, &validationErr) {
+		t.Fatalf("expected a validation error, got: %v", err)
+	}
+	assert.Equal(t, []string{
+		"$.Labels[0].intervalSeconds: must be positive, got 0",
+		"$.Labels[1]: one of label or selector is required",
+		"$.Labels[1].retentionPeriodHours: must be positive, got 0",
+		"$.Descriptions[0].schedule: invalid schedule: expected exactly 5 fields, found 2: [* *]",
+		"$.Descriptions[0].retention: must keep at least one snapshot in some tier",
+		`$.Descriptions[1].schedule: invalid schedule: "@every 1h" is not a calendar schedule, use intervalSeconds for fixed intervals`,
+	}, validationErr.Problems)
+}
+
+func TestParseYAML(t *testing.T) {
+	sc, err := ParseYAML([]byte(`
+Labels:
+  - label:
+      key: name
+      value: some-app-name
+    schedule:
+      cron: "30 2 * * *"
+      timezone: Europe/London
+    retention:
+      hourly: 24
+      daily: 14
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Len(t, sc.Labels, 1)
+	assert.Equal(t, "some-app-name", sc.Labels[0].Label.Value)
+	assert.Equal(t, "30 2 * * *", sc.Labels[0].Schedule.Cron)
+	assert.Equal(t, 24, sc.Labels[0].Retention.Hourly)
+
+	_, err = ParseYAML([]byte(`
+Labels:
+  - label: {key: name, value: some-app-name}
+    intervalSecond: 60
+`))
+	assert.EqualError(t, err, `error unmarshalling snapshot config file: json: unknown field "intervalSecond"`)
+}
@@ -0,0 +1,102 @@
+package config
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/metrics"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/models"
+)
+
+// Time to wait for writes to the configuration file to settle before reloading
+const reloadDelay = 500 * time.Millisecond
+
+// Reloader re-parses the snapshot configuration file when it changes on disk
+// or when the process receives a SIGHUP, and hands valid configurations to
+// OnReload. Invalid configurations are logged and ignored.
+type Reloader struct {
+	Path     string
+	Metrics  metrics.PrometheusInterface
+	OnReload func(sc *models.SnapshotConfigs)
+
+	content []byte
+}
+
+// Start begins watching the configuration file in the background
+func (r *Reloader) Start() error {
+	content, err := ioutil.ReadFile(r.Path)
+	if err != nil {
+		return errors.Wrap(err, "error reading snapshot config file")
+	}
+	r.content = content
+
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return errors.Wrap(err, "error creating file watcher")
+	}
+	// Watch the parent directory, as config maps are updated by swapping a
+	// symlink rather than writing to the file itself
+	if err := watcher.Add(filepath.Dir(r.Path)); err != nil {
+		watcher.Close()
+		return errors.Wrap(err, "error watching snapshot config directory")
+	}
+
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+
+	go func() {
+		defer watcher.Close()
+		var settled <-chan time.Time
+		for {
+			select {
+			case <-watcher.Events:
+				settled = time.After(reloadDelay)
+			case <-settled:
+				r.reload(false)
+			case err := <-watcher.Errors:
+				log.Error("error watching snapshot config file: ", err)
+			case <-hup:
+				log.Info("Received SIGHUP, reloading snapshot config")
+				r.reload(true)
+			}
+		}
+	}()
+
+	return nil
+}
+
+// reload parses the configuration file if its content changed, or always
+// when forced
+func (r *Reloader) reload(force bool) {
+	content, err := ioutil.ReadFile(r.Path)
+	if err != nil {
+		// The file may be briefly missing while being replaced
+		log.Warn("error reading snapshot config file: ", err)
+		if force {
+			r.Metrics.UpdateConfigReloadStatus(false)
+		}
+		return
+	}
+	if !force && bytes.Equal(content, r.content) {
+		return
+	}
+	r.content = content
+
+	sc, err := parseFile(r.Path, content)
+	if err != nil {
+		log.Error("Keeping previous snapshot config: ", err)
+		r.Metrics.UpdateConfigReloadStatus(false)
+		return
+	}
+	log.Info("Reloaded snapshot config from: ", r.Path)
+	r.Metrics.UpdateConfigReloadStatus(true)
+	r.OnReload(sc)
+}
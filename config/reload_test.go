@@ -0,0 +1,122 @@
+package config
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/metrics"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/models"
+)
+
+const (
+	reloadTestConfig = `{"Labels": [{"intervalSeconds": 3600, "retentionPeriodHours": 24, "label": {"key": "name", "value": "a"}}]}`
+	reloadTestUpdate = `{"Labels": [{"intervalSeconds": 3600, "retentionPeriodHours": 48, "label": {"key": "name", "value": "b"}}]}`
+)
+
+// newTestReloader returns a reloader of a temporary config file with the given
+// content, and the configs it reloaded
+func newTestReloader(t *testing.T, m metrics.PrometheusInterface, content string) (*Reloader, *[]*models.SnapshotConfigs) {
+	dir, err := ioutil.TempDir("", "reload")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+
+	path := filepath.Join(dir, "config.json")
+	writeTestConfig(t, path, content)
+
+	reloaded := []*models.SnapshotConfigs{}
+	r := &Reloader{
+		Path:     path,
+		Metrics:  m,
+		OnReload: func(sc *models.SnapshotConfigs) { reloaded = append(reloaded, sc) },
+		content:  []byte(content),
+	}
+	return r, &reloaded
+}
+
+func writeTestConfig(t *testing.T, path, content string) {
+	if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestReloadOnChange(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	m := metrics.NewMockPrometheusInterface(mockCtrl)
+
+	r, reloaded := newTestReloader(t, m, reloadTestConfig)
+	writeTestConfig(t, r.Path, reloadTestUpdate)
+
+	m.EXPECT().UpdateConfigReloadStatus(true).Times(1)
+	r.reload(false)
+
+	if assert.Len(t, *reloaded, 1) {
+		assert.Equal(t, "b", (*reloaded)[0].Labels[0].Label.Value)
+	}
+}
+
+func TestReloadKeepsPreviousConfigWhenInvalid(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	m := metrics.NewMockPrometheusInterface(mockCtrl)
+
+	r, reloaded := newTestReloader(t, m, reloadTestConfig)
+	writeTestConfig(t, r.Path, `{"Labels": [`)
+
+	m.EXPECT().UpdateConfigReloadStatus(false).Times(1)
+	r.reload(false)
+	assert.Empty(t, *reloaded)
+
+	// Fixing the file reloads it
+	writeTestConfig(t, r.Path, reloadTestUpdate)
+	m.EXPECT().UpdateConfigReloadStatus(true).Times(1)
+	r.reload(false)
+	assert.Len(t, *reloaded, 1)
+}
+
+func TestReloadIgnoresUnchangedContentUnlessForced(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	m := metrics.NewMockPrometheusInterface(mockCtrl)
+
+	r, reloaded := newTestReloader(t, m, reloadTestConfig)
+
+	// Nothing changed
+	r.reload(false)
+	assert.Empty(t, *reloaded)
+
+	// SIGHUP reloads anyway
+	m.EXPECT().UpdateConfigReloadStatus(true).Times(1)
+	r.reload(true)
+	assert.Len(t, *reloaded, 1)
+}
+
+func TestReloaderWatchesFile(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+	m := metrics.NewMockPrometheusInterface(mockCtrl)
+
+	r, _ := newTestReloader(t, m, reloadTestConfig)
+	done := make(chan *models.SnapshotConfigs, 1)
+	r.OnReload = func(sc *models.SnapshotConfigs) { done <- sc }
+	m.EXPECT().UpdateConfigReloadStatus(true).Times(1)
+
+	if err := r.Start(); err != nil {
+		t.Fatal(err)
+	}
+	writeTestConfig(t, r.Path, reloadTestUpdate)
+
+	select {
+	case sc := <-done:
+		assert.Equal(t, "b", sc.Labels[0].Label.Value)
+	case <-time.After(5 * time.Second):
+		t.Fatal("config was not reloaded")
+	}
+}
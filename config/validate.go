@@ -0,0 +1,186 @@
+package config
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/models"
+)
+
+// ValidationError holds every problem found in a configuration, each one
+// prefixed with the JSON path of the offending field
+type ValidationError struct {
+	Problems []string
+}
+
+func (e *ValidationError) Error() string {
+	return strings.Join(e.Problems, "; ")
+}
+
+// Label values may only contain lowercase letters, numeric characters,
+// underscores and dashes, and be at most 63 characters long
+var labelValueRegexp = regexp.MustCompile(`^[a-z0-9_-]{1,63}$`)
+
+// ValidateOwner checks that owner can be used as the value of the snapshotter
+// label
+func ValidateOwner(owner string) error {
+	if !labelValueRegexp.MatchString(owner) {
+		return fmt.Errorf("%q is not a valid label value: must be 1-63 lowercase letters, numeric characters, underscores or dashes", owner)
+	}
+	return nil
+}
+
+type validator struct {
+	problems []string
+}
+
+func (v *validator) addf(path, format string, args ...interface{}) {
+	v.problems = append(v.problems, fmt.Sprintf("%s: %s", path, fmt.Sprintf(format, args...)))
+}
+
+// validate checks that the watcher can use the given configuration
+func validate(sc *models.SnapshotConfigs) error {
+	v := &validator{}
+	for i, l := range sc.Labels {
+		path := fmt.Sprintf("$.Labels[%d]", i)
+		if l == nil {
+			v.addf(path, "must not be null")
+			continue
+		}
+		switch {
+		case l.Label == nil && l.Selector == nil:
+			v.addf(path, "one of label or selector is required")
+		case l.Label != nil && l.Selector != nil:
+			v.addf(path, "only one of label or selector may be set")
+		case l.Label != nil && l.Label.Key == "":
+			v.addf(path+".label.key", "must not be empty")
+		case l.Selector != nil:
+			v.validateSelector(path+".selector", l.Selector)
+		}
+		v.validateTarget(path, l.IntervalSeconds, l.RetentionPeriodHours, l.Retention, l.Schedule, l.MinKeep)
+		v.validateOwner(path+".owner", l.Owner)
+	}
+	for i, d := range sc.Descriptions {
+		path := fmt.Sprintf("$.Descriptions[%d]", i)
+		if d == nil {
+			v.addf(path, "must not be null")
+			continue
+		}
+		if d.Description == nil {
+			v.addf(path+".description", "is required")
+		} else if d.Description.Key == "" {
+			v.addf(path+".description.key", "must not be empty")
+		}
+		v.validateTarget(path, d.IntervalSeconds, d.RetentionPeriodHours, d.Retention, d.Schedule, d.MinKeep)
+		v.validateOwner(path+".owner", d.Owner)
+	}
+
+	if len(v.problems) > 0 {
+		return &ValidationError{Problems: v.problems}
+	}
+	return nil
+}
+
+// validateTarget checks the settings shared by label and description targets
+func (v *validator) validateTarget(path string, intervalSeconds, retentionPeriodHours int64, retention *models.RetentionPolicy, schedule *models.Schedule, minKeep int) {
+	if minKeep < 0 {
+		v.addf(path+".minKeep", "must not be negative, got %d", minKeep)
+	}
+
+	if schedule == nil {
+		if intervalSeconds <= 0 {
+			v.addf(path+".intervalSeconds", "must be positive, got %d", intervalSeconds)
+		}
+	} else {
+		v.validateSchedule(path+".schedule", schedule)
+	}
+
+	if retention == nil {
+		// Without retention tiers a zero period would delete every snapshot
+		if retentionPeriodHours <= 0 {
+			v.addf(path+".retentionPeriodHours", "must be positive, got %d", retentionPeriodHours)
+		}
+		return
+	}
+	if retentionPeriodHours < 0 {
+		v.addf(path+".retentionPeriodHours", "must not be negative, got %d", retentionPeriodHours)
+	}
+	v.validateRetention(path+".retention", retention)
+}
+
+func (v *validator) validateOwner(path, owner string) {
+	// Targets without an owner use the one of the snapshotter
+	if owner == "" {
+		return
+	}
+	if err := ValidateOwner(owner); err != nil {
+		v.addf(path, "%v", err)
+	}
+}
+
+func (v *validator) validateSelector(path string, s *models.LabelSelector) {
+	if len(s.MatchLabels) == 0 && len(s.MatchExpressions) == 0 {
+		v.addf(path, "must have at least one of matchLabels or matchExpressions")
+	}
+	for k := range s.MatchLabels {
+		if k == "" {
+			v.addf(path+".matchLabels", "keys must not be empty")
+		}
+	}
+	for i, r := range s.MatchExpressions {
+		rPath := fmt.Sprintf("%s.matchExpressions[%d]", path, i)
+		if r == nil {
+			v.addf(rPath, "must not be null")
+			continue
+		}
+		if r.Key == "" {
+			v.addf(rPath+".key", "must not be empty")
+		}
+		switch r.Operator {
+		case models.SelectorOpIn, models.SelectorOpNotIn:
+			if len(r.Values) == 0 {
+				v.addf(rPath+".values", "must not be empty for operator %s", r.Operator)
+			}
+		case models.SelectorOpExists, models.SelectorOpDoesNotExist:
+			if len(r.Values) > 0 {
+				v.addf(rPath+".values", "must be empty for operator %s", r.Operator)
+			}
+		default:
+			v.addf(rPath+".operator", "must be one of %s, %s, %s or %s, got %q",
+				models.SelectorOpIn, models.SelectorOpNotIn, models.SelectorOpExists, models.SelectorOpDoesNotExist, r.Operator)
+		}
+	}
+}
+
+func (v *validator) validateRetention(path string, r *models.RetentionPolicy) {
+	total := 0
+	for _, tier := range []struct {
+		name  string
+		count int
+	}{
+		{"hourly", r.Hourly},
+		{"daily", r.Daily},
+		{"weekly", r.Weekly},
+		{"monthly", r.Monthly},
+		{"yearly", r.Yearly},
+	} {
+		if tier.count < 0 {
+			v.addf(path+"."+tier.name, "must not be negative, got %d", tier.count)
+		}
+		total += tier.count
+	}
+	if total <= 0 {
+		v.addf(path, "must keep at least one snapshot in some tier")
+	}
+}
+
+func (v *validator) validateSchedule(path string, s *models.Schedule) {
+	if s.Cron == "" {
+		v.addf(path+".cron", "is required")
+		return
+	}
+	if _, err := s.Parse(); err != nil {
+		v.addf(path, "invalid schedule: %v", err)
+	}
+}
@@ -1,13 +1,18 @@
 package main
 
 import (
-	"encoding/json"
+	"context"
 	"flag"
-	"io/ioutil"
+	"fmt"
 	"os"
+	"os/signal"
 	"strings"
+	"syscall"
+	"time"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/config"
 	"github.com/utilitywarehouse/gcp-disk-snapshotter/metrics"
 	"github.com/utilitywarehouse/gcp-disk-snapshotter/models"
 	"github.com/utilitywarehouse/gcp-disk-snapshotter/snapshot"
@@ -17,13 +22,44 @@ import (
 var (
 	// flags
 	flagProject       = flag.String("project", "", "(Required) GCP Project to use")
-	flagZones         = flag.String("zones", "", "(Required) Comma separated list of zones where projects disks may live")
-	flagConfFile      = flag.String("conf_file", "", "(Required) Path of the configuration file tha contains the targets based on label or description")
+	flagZones         = flag.String("zones", "", "Comma separated list of zones where projects disks may live. Defaults to all zones of the project")
+	flagConfFile      = flag.String("conf_file", "", "(Required) Path of the configuration file tha contains the targets based on label or description (JSON, or YAML with a .yaml/.yml extension)")
 	flagSnapPrefix    = flag.String("snap_prefix", "", "Prefix for created snapshots")
 	flagWatchInterval = flag.Int("watch_interval", 60, "Interval between watch cycles in seconds. Defaults to 60s")
 	flagLogLevel      = flag.String("log_level", "info", "Log Level, defaults to INFO")
+	flagOwner         = flag.String("owner", snapshot.SnapshotterLabelValue, "Value of the gcp_disk_snapshotter label that marks the snapshots owned by this instance, unless a target sets its own owner")
+	flagOnce          = flag.Bool("once", false, "Run a single watch cycle, wait for its operations to finish and exit. Exits non-zero if anything failed")
+	flagDryRun        = flag.Bool("dry_run", false, "Only log the snapshots that would be created and deleted, without changing anything")
+	flagMinKeep       = flag.Int("min_keep", 1, "Minimum number of READY snapshots to keep per disk regardless of retention, unless a target sets a higher minKeep")
+	flagOpTimeout     = flag.Duration("operation_timeout", 6*time.Hour, "Time after which a create or delete operation stops being polled, frees its poll slot and is counted as timed out, while it may still succeed. Keep it above how long first snapshots of the largest disks take. 0 to poll until the operation finishes")
+	flagMaxPolledOps  = flag.Int("max_polled_operations", 100, "Maximum number of create and delete operations polled at once. New operations wait for a free slot. 0 for no limit")
+	flagWorkers       = flag.Int("workers", 4, "Number of disks checked concurrently")
+	flagMaxInFlight   = flag.Int("max_inflight_calls", 10, "Maximum number of snapshot create and delete api calls in flight at once. 0 for no limit")
+	flagCallsPerSec   = flag.Float64("calls_per_second", 5, "Maximum number of snapshot create and delete api calls per second. 0 for no limit")
+	flagHealthCycles  = flag.Int("health_cycle_intervals", 3, "Number of watch intervals without a finished watch cycle after which the watch-cycle health check fails")
+	flagRPOIntervals  = flag.Float64("rpo_intervals", 2, "Number of target intervals without a READY snapshot after which a disk is reported past its RPO by the disk-rpo health check")
+	flagListenAddress = flag.String("listen_address", ":5000", "Address to serve the metrics and operational endpoints on")
+	flagTLSCertFile   = flag.String("tls_cert_file", "", "Path of the TLS certificate to serve HTTPS with. Requires -tls_key_file")
+	flagTLSKeyFile    = flag.String("tls_key_file", "", "Path of the TLS key to serve HTTPS with. Requires -tls_cert_file")
+	flagStatusName    = flag.String("status_name", "gcp-disk-snapshotter", "Name of the instance reported on /__/about")
+	flagStatusOwner   = flag.String("status_owner", "infrastructure", "Owner of the instance reported on /__/about")
+	flagStatusSlack   = flag.String("status_owner_slack", "#infra", "Slack channel of the owner reported on /__/about")
+	flagStatusLinks   = flag.String("status_links", "github=https://github.com/utilitywarehouse/gcp-disk-snapshotter", "Comma separated list of description=url links reported on /__/about")
+	flagShutdownGrace = flag.Duration("shutdown_grace_period", 25*time.Second, "Time to wait for pending operations to finish on SIGTERM/SIGINT before exiting. Keep it below the termination grace period of the pod")
 )
 
+func init() {
+	flag.Usage = func() {
+		out := flag.CommandLine.Output()
+		fmt.Fprintf(out, "Usage: %s [flags] [command]\n\n", os.Args[0])
+		fmt.Fprintf(out, "Commands:\n")
+		fmt.Fprintf(out, "  validate\tValidate the configuration file and exit (only requires -conf_file)\n")
+		fmt.Fprintf(out, "  plan\t\tPrint the snapshots that would be kept, deleted and created per disk and exit\n\n")
+		fmt.Fprintf(out, "Flags:\n")
+		flag.PrintDefaults()
+	}
+}
+
 func usage() {
 	flag.Usage()
 	os.Exit(2)
@@ -46,20 +82,47 @@ func main() {
 	// Flag Parsing
 	flag.Parse()
 
+	command := flag.Arg(0)
+	switch command {
+	case "", "plan":
+	case "validate":
+		if *flagConfFile == "" {
+			usage()
+		}
+		os.Exit(validateSnapshotConfig(*flagConfFile))
+	default:
+		usage()
+	}
+
 	if *flagProject == "" {
 		usage()
 	}
 	project := *flagProject
 
-	if *flagZones == "" {
-		usage()
+	var zones []string
+	if *flagZones != "" {
+		zones = strings.Split(*flagZones, ",")
 	}
-	zones := strings.Split(*flagZones, ",")
 
 	if *flagConfFile == "" {
 		usage()
 	}
 
+	if err := config.ValidateOwner(*flagOwner); err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -owner: %v\n", err)
+		usage()
+	}
+
+	if (*flagTLSCertFile == "") != (*flagTLSKeyFile == "") {
+		fmt.Fprintf(os.Stderr, "-tls_cert_file and -tls_key_file must be set together\n")
+		usage()
+	}
+	links, err := parseLinks(*flagStatusLinks)
+	if err != nil {
+		fmt.Fprintf(os.Stderr, "invalid -status_links: %v\n", err)
+		usage()
+	}
+
 	snapPrefix := *flagSnapPrefix
 	watchInterval := *flagWatchInterval
 	logLevel := *flagLogLevel
@@ -70,43 +133,147 @@ func main() {
 	// Load config
 	snapshotConfigs := loadSnapshotConfig(*flagConfFile)
 
-	log.Debug("Reading Configuration")
-	for _, d := range snapshotConfigs.Descriptions {
-		log.Debug("description: ", d.Description.Key, " ", d.Description.Value)
+	// Create a snapshotter
+	gsc := snapshot.CreateGCPSnapClient(project, snapPrefix, zones)
+
+	watcher := &watch.Watcher{
+		GSC:           gsc,
+		WatchInterval: watchInterval,
+		MinKeep:       *flagMinKeep,
+		Owner:         *flagOwner,
+		DryRun:        *flagDryRun,
+
+		ShutdownGracePeriod: *flagShutdownGrace,
+		OperationTimeout:    *flagOpTimeout,
+		MaxPolledOperations: *flagMaxPolledOps,
+		Workers:             *flagWorkers,
+		MaxInFlightCalls:    *flagMaxInFlight,
+		CallsPerSecond:      *flagCallsPerSec,
 	}
-	for _, l := range snapshotConfigs.Labels {
-		log.Debug("label: ", l.Label.Key, " ", l.Label.Value)
+	watcher.SetConfig(snapshotConfigs)
+
+	// Stop starting new work on SIGTERM/SIGINT
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	sigs := make(chan os.Signal, 1)
+	signal.Notify(sigs, syscall.SIGTERM, syscall.SIGINT)
+	go func() {
+		sig := <-sigs
+		log.Info("Received ", sig, ", shutting down")
+		cancel()
+	}()
+
+	if command == "plan" {
+		os.Exit(planSnapshots(ctx, watcher))
 	}
 
 	// Init metrics
 	metrics := &metrics.Prometheus{}
 	metrics.Init()
+	watcher.Metrics = metrics
+	gsc.Metrics = metrics
 
-	// Create a snapshotter
-	gsc := snapshot.CreateGCPSnapClient(project, snapPrefix, zones)
+	// Serve the metrics and operational endpoints
+	go startServer(serverConfig{
+		listenAddress: *flagListenAddress,
+		tlsCertFile:   *flagTLSCertFile,
+		tlsKeyFile:    *flagTLSKeyFile,
+		name:          *flagStatusName,
+		owner:         *flagStatusOwner,
+		ownerSlack:    *flagStatusSlack,
+		links:         links,
+	}, &healthChecks{
+		watcher:        watcher,
+		gsc:            gsc,
+		started:        time.Now(),
+		cycleIntervals: *flagHealthCycles,
+		rpoIntervals:   *flagRPOIntervals,
+	})
 
-	// Start watching
-	watcher := &watch.Watcher{
-		GSC:           gsc,
-		WatchInterval: watchInterval,
-		Metrics:       metrics,
+	if watcher.DryRun {
+		log.Warn("Running in dry run mode, no snapshots will be created or deleted")
 	}
-	watcher.Watch(snapshotConfigs)
+
+	if *flagOnce {
+		if err := watcher.RunOnce(ctx); err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	// Reload config on changes
+	reloader := &config.Reloader{
+		Path:    *flagConfFile,
+		Metrics: metrics,
+		OnReload: func(sc *models.SnapshotConfigs) {
+			logSnapshotConfig(sc)
+			watcher.SetConfig(sc)
+		},
+	}
+	if err := reloader.Start(); err != nil {
+		log.Fatal("Error watching snapshot config file: ", err)
+	}
+
+	// Start watching, then wait for the pending operations
+	watcher.Watch(ctx)
+	watcher.Shutdown()
 
 }
 
 func loadSnapshotConfig(snapshotConfigFile string) *models.SnapshotConfigs {
-	confFile, err := os.Open(snapshotConfigFile)
+	snapshotConfigs, err := config.Load(snapshotConfigFile)
 	if err != nil {
-		log.Fatal("Error while opening volume snapshot config file: ", err)
+		log.Fatal("Error loading snapshot config file: ", err)
 	}
-	fileContent, err := ioutil.ReadAll(confFile)
+	logSnapshotConfig(snapshotConfigs)
+	return snapshotConfigs
+}
+
+// planSnapshots prints the plan of every disk of the configured targets and
+// returns the exit code for the plan command
+func planSnapshots(ctx context.Context, watcher *watch.Watcher) int {
+	plans, err := watcher.Plan(ctx)
 	if err != nil {
-		log.Fatal("Error while reading volume snapshot config file: ", err)
+		fmt.Fprintf(os.Stderr, "error planning snapshots: %v\n", err)
+		return 1
 	}
-	snapshotConfigs := &models.SnapshotConfigs{}
-	if err = json.Unmarshal(fileContent, snapshotConfigs); err != nil {
-		log.Fatal("Error unmarshalling snapshots config file: ", err)
+	if err := watch.WritePlan(os.Stdout, plans); err != nil {
+		fmt.Fprintf(os.Stderr, "error writing plan: %v\n", err)
+		return 1
+	}
+	return 0
+}
+
+// validateSnapshotConfig prints every problem found in the configuration file
+// and returns the exit code for the validate command
+func validateSnapshotConfig(snapshotConfigFile string) int {
+	if _, err := config.Load(snapshotConfigFile); err != nil {
+		var validationErr *config.ValidationError
+		if errors.As(err, &validationErr) {
+			fmt.Fprintf(os.Stderr, "%s: %d problem(s) found:\n", snapshotConfigFile, len(validationErr.Problems))
+			for _, p := range validationErr.Problems {
+				fmt.Fprintf(os.Stderr, "  %s\n", p)
+			}
+		} else {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", snapshotConfigFile, err)
+		}
+		return 1
+	}
+	fmt.Printf("%s: configuration is valid\n", snapshotConfigFile)
+	return 0
+}
+
+func logSnapshotConfig(snapshotConfigs *models.SnapshotConfigs) {
+	log.Debug("Reading Configuration")
+	for _, d := range snapshotConfigs.Descriptions {
+		log.Debug("description: ", d.Description.Key, " ", d.Description.Value)
+	}
+	for _, l := range snapshotConfigs.Labels {
+		if l.Selector != nil {
+			log.Debug("selector: ", l.Selector)
+			continue
+		}
+		log.Debug("label: ", l.Label.Key, " ", l.Label.Value)
 	}
-	return snapshotConfigs
 }
@@ -7,6 +7,7 @@ package metrics
 import (
 	gomock "github.com/golang/mock/gomock"
 	reflect "reflect"
+	time "time"
 )
 
 // MockPrometheusInterface is a mock of PrometheusInterface interface
@@ -71,3 +72,113 @@ func (m *MockPrometheusInterface) UpdateOperationStatus(operation_type string, s
 func (mr *MockPrometheusInterfaceMockRecorder) UpdateOperationStatus(operation_type, success interface{}) *gomock.Call {
 	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOperationStatus", reflect.TypeOf((*MockPrometheusInterface)(nil).UpdateOperationStatus), operation_type, success)
 }
+
+// UpdateOperationTimeoutCount mocks base method
+func (m *MockPrometheusInterface) UpdateOperationTimeoutCount(operation_type string) {
+	m.ctrl.Call(m, "UpdateOperationTimeoutCount", operation_type)
+}
+
+// UpdateOperationTimeoutCount indicates an expected call of UpdateOperationTimeoutCount
+func (mr *MockPrometheusInterfaceMockRecorder) UpdateOperationTimeoutCount(operation_type interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateOperationTimeoutCount", reflect.TypeOf((*MockPrometheusInterface)(nil).UpdateOperationTimeoutCount), operation_type)
+}
+
+// ObserveOperationDuration mocks base method
+func (m *MockPrometheusInterface) ObserveOperationDuration(operation_type, action string, success bool, duration time.Duration) {
+	m.ctrl.Call(m, "ObserveOperationDuration", operation_type, action, success, duration)
+}
+
+// ObserveOperationDuration indicates an expected call of ObserveOperationDuration
+func (mr *MockPrometheusInterfaceMockRecorder) ObserveOperationDuration(operation_type, action, success, duration interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ObserveOperationDuration", reflect.TypeOf((*MockPrometheusInterface)(nil).ObserveOperationDuration), operation_type, action, success, duration)
+}
+
+// ObserveAPICall mocks base method
+func (m *MockPrometheusInterface) ObserveAPICall(method, code string, duration time.Duration) {
+	m.ctrl.Call(m, "ObserveAPICall", method, code, duration)
+}
+
+// ObserveAPICall indicates an expected call of ObserveAPICall
+func (mr *MockPrometheusInterfaceMockRecorder) ObserveAPICall(method, code, duration interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ObserveAPICall", reflect.TypeOf((*MockPrometheusInterface)(nil).ObserveAPICall), method, code, duration)
+}
+
+// ObserveWatchCycleDuration mocks base method
+func (m *MockPrometheusInterface) ObserveWatchCycleDuration(duration time.Duration) {
+	m.ctrl.Call(m, "ObserveWatchCycleDuration", duration)
+}
+
+// ObserveWatchCycleDuration indicates an expected call of ObserveWatchCycleDuration
+func (mr *MockPrometheusInterfaceMockRecorder) ObserveWatchCycleDuration(duration interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ObserveWatchCycleDuration", reflect.TypeOf((*MockPrometheusInterface)(nil).ObserveWatchCycleDuration), duration)
+}
+
+// UpdateDiskCheckStatus mocks base method
+func (m *MockPrometheusInterface) UpdateDiskCheckStatus(disk string, success bool) {
+	m.ctrl.Call(m, "UpdateDiskCheckStatus", disk, success)
+}
+
+// UpdateDiskCheckStatus indicates an expected call of UpdateDiskCheckStatus
+func (mr *MockPrometheusInterfaceMockRecorder) UpdateDiskCheckStatus(disk, success interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateDiskCheckStatus", reflect.TypeOf((*MockPrometheusInterface)(nil).UpdateDiskCheckStatus), disk, success)
+}
+
+// UpdateAPIRetryCount mocks base method
+func (m *MockPrometheusInterface) UpdateAPIRetryCount(method, reason string) {
+	m.ctrl.Call(m, "UpdateAPIRetryCount", method, reason)
+}
+
+// UpdateAPIRetryCount indicates an expected call of UpdateAPIRetryCount
+func (mr *MockPrometheusInterfaceMockRecorder) UpdateAPIRetryCount(method, reason interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateAPIRetryCount", reflect.TypeOf((*MockPrometheusInterface)(nil).UpdateAPIRetryCount), method, reason)
+}
+
+// UpdateDiskSnapshotStats mocks base method
+func (m *MockPrometheusInterface) UpdateDiskSnapshotStats(target Target, disk, location string, lastReady time.Time, count int, storageBytes int64) {
+	m.ctrl.Call(m, "UpdateDiskSnapshotStats", target, disk, location, lastReady, count, storageBytes)
+}
+
+// UpdateDiskSnapshotStats indicates an expected call of UpdateDiskSnapshotStats
+func (mr *MockPrometheusInterfaceMockRecorder) UpdateDiskSnapshotStats(target, disk, location, lastReady, count, storageBytes interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateDiskSnapshotStats", reflect.TypeOf((*MockPrometheusInterface)(nil).UpdateDiskSnapshotStats), target, disk, location, lastReady, count, storageBytes)
+}
+
+// DeleteDiskSnapshotStats mocks base method
+func (m *MockPrometheusInterface) DeleteDiskSnapshotStats(target Target, disk, location string) {
+	m.ctrl.Call(m, "DeleteDiskSnapshotStats", target, disk, location)
+}
+
+// DeleteDiskSnapshotStats indicates an expected call of DeleteDiskSnapshotStats
+func (mr *MockPrometheusInterfaceMockRecorder) DeleteDiskSnapshotStats(target, disk, location interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteDiskSnapshotStats", reflect.TypeOf((*MockPrometheusInterface)(nil).DeleteDiskSnapshotStats), target, disk, location)
+}
+
+// UpdateTargetInterval mocks base method
+func (m *MockPrometheusInterface) UpdateTargetInterval(target Target, interval time.Duration) {
+	m.ctrl.Call(m, "UpdateTargetInterval", target, interval)
+}
+
+// UpdateTargetInterval indicates an expected call of UpdateTargetInterval
+func (mr *MockPrometheusInterfaceMockRecorder) UpdateTargetInterval(target, interval interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateTargetInterval", reflect.TypeOf((*MockPrometheusInterface)(nil).UpdateTargetInterval), target, interval)
+}
+
+// DeleteTargetInterval mocks base method
+func (m *MockPrometheusInterface) DeleteTargetInterval(target Target) {
+	m.ctrl.Call(m, "DeleteTargetInterval", target)
+}
+
+// DeleteTargetInterval indicates an expected call of DeleteTargetInterval
+func (mr *MockPrometheusInterfaceMockRecorder) DeleteTargetInterval(target interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteTargetInterval", reflect.TypeOf((*MockPrometheusInterface)(nil).DeleteTargetInterval), target)
+}
+
+// UpdateConfigReloadStatus mocks base method
+func (m *MockPrometheusInterface) UpdateConfigReloadStatus(success bool) {
+	m.ctrl.Call(m, "UpdateConfigReloadStatus", success)
+}
+
+// UpdateConfigReloadStatus indicates an expected call of UpdateConfigReloadStatus
+func (mr *MockPrometheusInterfaceMockRecorder) UpdateConfigReloadStatus(success interface{}) *gomock.Call {
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "UpdateConfigReloadStatus", reflect.TypeOf((*MockPrometheusInterface)(nil).UpdateConfigReloadStatus), success)
+}
@@ -1,19 +1,29 @@
 package metrics
 
 import (
-	"net/http"
 	"strconv"
+	"time"
 
 	"github.com/prometheus/client_golang/prometheus"
-	"github.com/prometheus/client_golang/prometheus/promhttp"
-	log "github.com/sirupsen/logrus"
-	"github.com/utilitywarehouse/go-operational/op"
 )
 
 type Prometheus struct {
 	createSnapshotSuccess *prometheus.CounterVec
 	deleteSnapshotSuccess *prometheus.CounterVec
 	operationSuccess      *prometheus.CounterVec
+	operationTimeouts     *prometheus.CounterVec
+	operationDuration     *prometheus.HistogramVec
+	apiCalls              *prometheus.CounterVec
+	apiCallDuration       *prometheus.HistogramVec
+	watchCycleDuration    prometheus.Histogram
+	diskCheckSuccess      *prometheus.CounterVec
+	apiRetries            *prometheus.CounterVec
+	lastReadySnapshot     *prometheus.GaugeVec
+	snapshotCount         *prometheus.GaugeVec
+	snapshotStorageBytes  *prometheus.GaugeVec
+	targetInterval        *prometheus.GaugeVec
+	configReloadSuccess   *prometheus.CounterVec
+	configLastReload      *prometheus.GaugeVec
 }
 
 // PrometheusInterface allows for mocking out the functionality of Prometheus when testing the full process of an apply run.
@@ -22,6 +32,37 @@ type PrometheusInterface interface {
 	UpdateCreateSnapshotStatus(disk string, success bool)
 	UpdateDeleteSnapshotStatus(disk string, success bool)
 	UpdateOperationStatus(operation_type string, success bool)
+	UpdateOperationTimeoutCount(operation_type string)
+	ObserveOperationDuration(operation_type, action string, success bool, duration time.Duration)
+	ObserveAPICall(method, code string, duration time.Duration)
+	ObserveWatchCycleDuration(duration time.Duration)
+	UpdateDiskCheckStatus(disk string, success bool)
+	UpdateAPIRetryCount(method, reason string)
+	UpdateDiskSnapshotStats(target Target, disk, location string, lastReady time.Time, count int, storageBytes int64)
+	DeleteDiskSnapshotStats(target Target, disk, location string)
+	UpdateTargetInterval(target Target, interval time.Duration)
+	DeleteTargetInterval(target Target)
+	UpdateConfigReloadStatus(success bool)
+}
+
+// Target identifies a target in the freshness metrics. Its name is not unique,
+// as several targets may match the same label with different owners or
+// retentions: its position in the configuration is.
+type Target struct {
+	Name   string
+	Owner  string
+	Config string
+}
+
+func (t Target) labels() prometheus.Labels {
+	return prometheus.Labels{"target": t.Name, "owner": t.Owner, "config": t.Config}
+}
+
+func (t Target) diskLabels(disk, location string) prometheus.Labels {
+	labels := t.labels()
+	labels["disk"] = disk
+	labels["location"] = location
+	return labels
 }
 
 func (p *Prometheus) Init() {
@@ -52,36 +93,171 @@ func (p *Prometheus) Init() {
 		Help: "Success metric for operations initiated by disk snapshotter",
 	},
 		[]string{
-			// Global or Zonal
+			// Global, Zonal or Regional
 			"operation_type",
 			// Result: true if the operation was successful, false otherwise
 			"success",
 		},
 	)
+	p.operationTimeouts = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcp_disk_snapshotter_operation_timeout_count",
+		Help: "Number of operations that stopped being polled after the operation timeout",
+	},
+		[]string{
+			// Global, Zonal or Regional
+			"operation_type",
+		},
+	)
+	p.operationDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gcp_disk_snapshotter_operation_duration_seconds",
+		Help:    "Time from starting a create or delete snapshot operation until it is done",
+		Buckets: prometheus.ExponentialBuckets(5, 2, 10),
+	},
+		[]string{
+			// Global, Zonal or Regional
+			"operation_type",
+			// Create or delete
+			"action",
+			// Result: true if the operation was successful, false otherwise
+			"success",
+		},
+	)
+	p.apiCalls = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcp_disk_snapshotter_compute_api_call_count",
+		Help: "Number of compute api calls, every attempt and every page of a listing counting as a call",
+	},
+		[]string{
+			// Compute api method, like Disks.CreateSnapshot
+			"method",
+			// ok, the HTTP status code of a failed call, or the kind of error
+			"code",
+		},
+	)
+	p.apiCallDuration = prometheus.NewHistogramVec(prometheus.HistogramOpts{
+		Name:    "gcp_disk_snapshotter_compute_api_call_duration_seconds",
+		Help:    "Latency of compute api calls, per attempt and per page of a listing",
+		Buckets: prometheus.ExponentialBuckets(0.05, 2, 12),
+	},
+		[]string{
+			// Compute api method, like Disks.CreateSnapshot
+			"method",
+		},
+	)
+	p.watchCycleDuration = prometheus.NewHistogram(prometheus.HistogramOpts{
+		Name:    "gcp_disk_snapshotter_watch_cycle_duration_seconds",
+		Help:    "Time to check all targets in a watch cycle, without waiting for the operations",
+		Buckets: prometheus.ExponentialBuckets(1, 2, 12),
+	})
+	p.diskCheckSuccess = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcp_disk_snapshotter_disk_check_count",
+		Help: "Success metric for the checks of a disk during a watch cycle, after retries",
+	},
+		[]string{
+			"disk",
+			// Result: true if all the calls for the disk were successful, false otherwise
+			"success",
+		},
+	)
+	p.apiRetries = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcp_disk_snapshotter_api_retry_count",
+		Help: "Number of retried compute api calls",
+	},
+		[]string{
+			// Compute api method, like Disks.CreateSnapshot
+			"method",
+			// HTTP status code of the failed call, or network
+			"reason",
+		},
+	)
+	p.lastReadySnapshot = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gcp_disk_snapshotter_last_ready_snapshot_timestamp_seconds",
+		Help: "Creation timestamp of the newest READY snapshot per disk, 0 if there is none",
+	},
+		[]string{
+			// Name, owner and position in the configuration of the target the
+			// disk matched
+			"target",
+			"owner",
+			"config",
+			"disk",
+			// Zone of a zonal disk, or region of a regional disk
+			"location",
+		},
+	)
+	p.snapshotCount = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gcp_disk_snapshotter_snapshot_count",
+		Help: "Number of snapshots per disk owned by the target",
+	},
+		[]string{
+			// Name, owner and position in the configuration of the target the
+			// disk matched
+			"target",
+			"owner",
+			"config",
+			"disk",
+			// Zone of a zonal disk, or region of a regional disk
+			"location",
+		},
+	)
+	p.snapshotStorageBytes = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gcp_disk_snapshotter_snapshot_storage_bytes",
+		Help: "Total storage bytes of the snapshots per disk owned by the target",
+	},
+		[]string{
+			// Name, owner and position in the configuration of the target the
+			// disk matched
+			"target",
+			"owner",
+			"config",
+			"disk",
+			// Zone of a zonal disk, or region of a regional disk
+			"location",
+		},
+	)
+	p.targetInterval = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gcp_disk_snapshotter_target_interval_seconds",
+		Help: "Configured time between snapshots per target, the current period for scheduled targets",
+	},
+		[]string{
+			"target",
+			"owner",
+			"config",
+		},
+	)
+	p.configReloadSuccess = prometheus.NewCounterVec(prometheus.CounterOpts{
+		Name: "gcp_disk_snapshotter_config_reload_count",
+		Help: "Success metric for reloads of the snapshot configuration file",
+	},
+		[]string{
+			// Result: true if the new configuration was loaded, false otherwise
+			"success",
+		},
+	)
+	p.configLastReload = prometheus.NewGaugeVec(prometheus.GaugeOpts{
+		Name: "gcp_disk_snapshotter_config_last_reload_timestamp_seconds",
+		Help: "Timestamp of the last reload attempt of the snapshot configuration file",
+	},
+		[]string{
+			// Result: true if the new configuration was loaded, false otherwise
+			"success",
+		},
+	)
 	prometheus.MustRegister(p.createSnapshotSuccess)
 	prometheus.MustRegister(p.deleteSnapshotSuccess)
 	prometheus.MustRegister(p.operationSuccess)
-
-	go startServer()
-}
-
-func startServer() {
-	log.Info("starting HTTP endpoints ...")
-
-	mux := http.NewServeMux()
-	mux.Handle("/__/", op.NewHandler(
-		op.NewStatus("gcp-disk-snapshotter", "gcp-disk-snapshotter handles snapshot creation/deletion on gcp for a given set of disks").
-			AddOwner("infrastructure", "#infra").
-			AddLink("github", "https://github.com/utilitywarehouse/gcp-disk-snapshotter").
-			SetRevision("master").
-			AddChecker("running", func(cr *op.CheckResponse) { cr.Healthy("service is running") }).
-			ReadyAlways(),
-	))
-	mux.Handle("/metrics", promhttp.Handler())
-
-	if err := http.ListenAndServe(":5000", mux); err != nil {
-		log.Fatal("could not start HTTP router: ", err)
-	}
+	prometheus.MustRegister(p.operationTimeouts)
+	prometheus.MustRegister(p.operationDuration)
+	prometheus.MustRegister(p.apiCalls)
+	prometheus.MustRegister(p.apiCallDuration)
+	prometheus.MustRegister(p.watchCycleDuration)
+	prometheus.MustRegister(p.diskCheckSuccess)
+	prometheus.MustRegister(p.apiRetries)
+	prometheus.MustRegister(p.lastReadySnapshot)
+	prometheus.MustRegister(p.snapshotCount)
+	prometheus.MustRegister(p.snapshotStorageBytes)
+	prometheus.MustRegister(p.targetInterval)
+	prometheus.MustRegister(p.configReloadSuccess)
+	prometheus.MustRegister(p.configLastReload)
 }
 
 // UpdateCreateSnapshotStatus increments the given disk's Counter for either successful create attempts or failed apply attempts.
@@ -104,3 +280,86 @@ func (p *Prometheus) UpdateOperationStatus(operation_type string, success bool)
 		"operation_type": operation_type, "success": strconv.FormatBool(success),
 	}).Inc()
 }
+
+// UpdateOperationTimeoutCount increments the Counter of timed out operations of the given type.
+func (p *Prometheus) UpdateOperationTimeoutCount(operation_type string) {
+	p.operationTimeouts.With(prometheus.Labels{
+		"operation_type": operation_type,
+	}).Inc()
+}
+
+// ObserveOperationDuration records the duration of a finished operation.
+func (p *Prometheus) ObserveOperationDuration(operation_type, action string, success bool, duration time.Duration) {
+	p.operationDuration.With(prometheus.Labels{
+		"operation_type": operation_type, "action": action, "success": strconv.FormatBool(success),
+	}).Observe(duration.Seconds())
+}
+
+// ObserveAPICall counts a compute api call and records its latency.
+func (p *Prometheus) ObserveAPICall(method, code string, duration time.Duration) {
+	p.apiCalls.With(prometheus.Labels{
+		"method": method, "code": code,
+	}).Inc()
+	p.apiCallDuration.With(prometheus.Labels{
+		"method": method,
+	}).Observe(duration.Seconds())
+}
+
+// ObserveWatchCycleDuration records the duration of a watch cycle.
+func (p *Prometheus) ObserveWatchCycleDuration(duration time.Duration) {
+	p.watchCycleDuration.Observe(duration.Seconds())
+}
+
+// UpdateDiskCheckStatus increments the given disk's Counter for either successful or failed checks during a watch cycle.
+func (p *Prometheus) UpdateDiskCheckStatus(disk string, success bool) {
+	p.diskCheckSuccess.With(prometheus.Labels{
+		"disk": disk, "success": strconv.FormatBool(success),
+	}).Inc()
+}
+
+// UpdateAPIRetryCount increments the Counter of retries for the given compute api method and reason.
+func (p *Prometheus) UpdateAPIRetryCount(method, reason string) {
+	p.apiRetries.With(prometheus.Labels{
+		"method": method, "reason": reason,
+	}).Inc()
+}
+
+// UpdateDiskSnapshotStats sets the freshness, count and storage gauges of the given disk of a target.
+func (p *Prometheus) UpdateDiskSnapshotStats(target Target, disk, location string, lastReady time.Time, count int, storageBytes int64) {
+	labels := target.diskLabels(disk, location)
+	lastReadyTimestamp := float64(0)
+	if !lastReady.IsZero() {
+		lastReadyTimestamp = float64(lastReady.Unix())
+	}
+	p.lastReadySnapshot.With(labels).Set(lastReadyTimestamp)
+	p.snapshotCount.With(labels).Set(float64(count))
+	p.snapshotStorageBytes.With(labels).Set(float64(storageBytes))
+}
+
+// DeleteDiskSnapshotStats removes the gauges of a disk that no longer matches the target.
+func (p *Prometheus) DeleteDiskSnapshotStats(target Target, disk, location string) {
+	labels := target.diskLabels(disk, location)
+	p.lastReadySnapshot.Delete(labels)
+	p.snapshotCount.Delete(labels)
+	p.snapshotStorageBytes.Delete(labels)
+}
+
+// UpdateTargetInterval sets the interval gauge of the given target.
+func (p *Prometheus) UpdateTargetInterval(target Target, interval time.Duration) {
+	p.targetInterval.With(target.labels()).Set(interval.Seconds())
+}
+
+// DeleteTargetInterval removes the interval gauge of a target that is no longer configured.
+func (p *Prometheus) DeleteTargetInterval(target Target) {
+	p.targetInterval.Delete(target.labels())
+}
+
+// UpdateConfigReloadStatus counts reloads of the snapshot configuration file and records the time of the last one.
+func (p *Prometheus) UpdateConfigReloadStatus(success bool) {
+	p.configReloadSuccess.With(prometheus.Labels{
+		"success": strconv.FormatBool(success),
+	}).Inc()
+	p.configLastReload.With(prometheus.Labels{
+		"success": strconv.FormatBool(success),
+	}).SetToCurrentTime()
+}
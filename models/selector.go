@@ -0,0 +1,98 @@
+package models
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// Operators of a LabelSelectorRequirement
+const (
+	SelectorOpIn           string = "In"
+	SelectorOpNotIn        string = "NotIn"
+	SelectorOpExists       string = "Exists"
+	SelectorOpDoesNotExist string = "DoesNotExist"
+)
+
+// LabelSelectorRequirement is a set based requirement on the value of a label,
+// in the style of Kubernetes label selectors
+type LabelSelectorRequirement struct {
+	Key      string   `json:"key"`
+	Operator string   `json:"operator"`
+	Values   []string `json:"values"`
+}
+
+// LabelSelector matches labels that have all of MatchLabels and satisfy all of
+// MatchExpressions
+type LabelSelector struct {
+	MatchLabels      map[string]string           `json:"matchLabels"`
+	MatchExpressions []*LabelSelectorRequirement `json:"matchExpressions"`
+}
+
+// Matches returns true if the given labels satisfy the selector
+func (s *LabelSelector) Matches(labels map[string]string) bool {
+	for k, v := range s.MatchLabels {
+		if val, ok := labels[k]; !ok || val != v {
+			return false
+		}
+	}
+	for _, r := range s.MatchExpressions {
+		if !r.Matches(labels) {
+			return false
+		}
+	}
+	return true
+}
+
+// Matches returns true if the given labels satisfy the requirement
+func (r *LabelSelectorRequirement) Matches(labels map[string]string) bool {
+	val, ok := labels[r.Key]
+	switch r.Operator {
+	case SelectorOpIn:
+		return ok && r.hasValue(val)
+	case SelectorOpNotIn:
+		return !ok || !r.hasValue(val)
+	case SelectorOpExists:
+		return ok
+	case SelectorOpDoesNotExist:
+		return !ok
+	}
+	return false
+}
+
+func (r *LabelSelectorRequirement) hasValue(val string) bool {
+	for _, v := range r.Values {
+		if v == val {
+			return true
+		}
+	}
+	return false
+}
+
+// String returns the selector in the Kubernetes selector syntax, for example
+// "env=prod,tier in (db,queue),!snapshot-opt-out"
+func (s *LabelSelector) String() string {
+	keys := []string{}
+	for k := range s.MatchLabels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	terms := []string{}
+	for _, k := range keys {
+		terms = append(terms, fmt.Sprintf("%s=%s", k, s.MatchLabels[k]))
+	}
+	for _, r := range s.MatchExpressions {
+		switch r.Operator {
+		case SelectorOpIn:
+			terms = append(terms, fmt.Sprintf("%s in (%s)", r.Key, strings.Join(r.Values, ",")))
+		case SelectorOpNotIn:
+			terms = append(terms, fmt.Sprintf("%s notin (%s)", r.Key, strings.Join(r.Values, ",")))
+		case SelectorOpExists:
+			terms = append(terms, r.Key)
+		case SelectorOpDoesNotExist:
+			terms = append(terms, "!"+r.Key)
+		}
+	}
+	return strings.Join(terms, ",")
+}
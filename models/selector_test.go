@@ -0,0 +1,36 @@
+package models
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLabelSelectorMatches(t *testing.T) {
+	selector := &LabelSelector{
+		MatchLabels: map[string]string{"env": "prod"},
+		MatchExpressions: []*LabelSelectorRequirement{
+			{Key: "tier", Operator: SelectorOpIn, Values: []string{"db", "queue"}},
+			{Key: "snapshot-opt-out", Operator: SelectorOpDoesNotExist},
+		},
+	}
+	assert.Equal(t, "env=prod,tier in (db,queue),!snapshot-opt-out", selector.String())
+
+	assert.True(t, selector.Matches(map[string]string{"env": "prod", "tier": "db"}))
+	assert.True(t, selector.Matches(map[string]string{"env": "prod", "tier": "queue", "team": "x"}))
+	assert.False(t, selector.Matches(map[string]string{"env": "dev", "tier": "db"}))
+	assert.False(t, selector.Matches(map[string]string{"env": "prod", "tier": "web"}))
+	assert.False(t, selector.Matches(map[string]string{"env": "prod"}))
+	assert.False(t, selector.Matches(map[string]string{"env": "prod", "tier": "db", "snapshot-opt-out": ""}))
+
+	notIn := &LabelSelector{
+		MatchExpressions: []*LabelSelectorRequirement{
+			{Key: "tier", Operator: SelectorOpNotIn, Values: []string{"web"}},
+			{Key: "env", Operator: SelectorOpExists},
+		},
+	}
+	assert.True(t, notIn.Matches(map[string]string{"env": "prod"}))
+	assert.True(t, notIn.Matches(map[string]string{"env": "prod", "tier": "db"}))
+	assert.False(t, notIn.Matches(map[string]string{"env": "prod", "tier": "web"}))
+	assert.False(t, notIn.Matches(map[string]string{"tier": "db"}))
+}
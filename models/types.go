@@ -1,14 +1,63 @@
 package models
 
+import (
+	"fmt"
+
+	"github.com/robfig/cron/v3"
+)
+
 type Label struct {
 	Key   string `json:"key"`
 	Value string `json:"value"`
 }
 
+// RetentionPolicy describes grandfather-father-son retention tiers. Every tier
+// keeps the newest snapshot of each of its most recent buckets, so for example
+// Daily: 14 keeps one snapshot per day for the last 14 days that have any.
+type RetentionPolicy struct {
+	Hourly  int `json:"hourly"`
+	Daily   int `json:"daily"`
+	Weekly  int `json:"weekly"`
+	Monthly int `json:"monthly"`
+	Yearly  int `json:"yearly"`
+}
+
+// Schedule is a standard 5 field cron expression, evaluated in the given
+// timezone (UTC if empty). When set, it replaces the IntervalSeconds of a target.
+type Schedule struct {
+	Cron     string `json:"cron"`
+	Timezone string `json:"timezone"`
+}
+
+// Parse returns the cron schedule described by s. Only calendar schedules are
+// supported: descriptors like @every have no previous activation to compare
+// snapshots with.
+func (s *Schedule) Parse() (cron.Schedule, error) {
+	spec := s.Cron
+	if s.Timezone != "" {
+		spec = fmt.Sprintf("CRON_TZ=%s %s", s.Timezone, s.Cron)
+	}
+	sched, err := cron.ParseStandard(spec)
+	if err != nil {
+		return nil, err
+	}
+	if _, ok := sched.(*cron.SpecSchedule); !ok {
+		return nil, fmt.Errorf("%q is not a calendar schedule, use intervalSeconds for fixed intervals", s.Cron)
+	}
+	return sched, nil
+}
+
+// LabelSnapshotConfig targets disks either by a single Label or by a set based
+// Selector. Only one of them may be set.
 type LabelSnapshotConfig struct {
-	Label                *Label `json:"label"`
-	IntervalSeconds      int64  `json:"intervalSeconds"`
-	RetentionPeriodHours int64  `json:"retentionPeriodHours"`
+	Label                *Label           `json:"label"`
+	Selector             *LabelSelector   `json:"selector"`
+	IntervalSeconds      int64            `json:"intervalSeconds"`
+	Schedule             *Schedule        `json:"schedule"`
+	RetentionPeriodHours int64            `json:"retentionPeriodHours"`
+	Retention            *RetentionPolicy `json:"retention"`
+	MinKeep              int              `json:"minKeep"`
+	Owner                string           `json:"owner"`
 }
 
 type Description struct {
@@ -17,9 +66,13 @@ type Description struct {
 }
 
 type DescriptionSnapshotConfig struct {
-	Description          *Description `json:"description"`
-	IntervalSeconds      int64        `json:"intervalSeconds"`
-	RetentionPeriodHours int64        `json:"retentionPeriodHours"`
+	Description          *Description     `json:"description"`
+	IntervalSeconds      int64            `json:"intervalSeconds"`
+	Schedule             *Schedule        `json:"schedule"`
+	RetentionPeriodHours int64            `json:"retentionPeriodHours"`
+	Retention            *RetentionPolicy `json:"retention"`
+	MinKeep              int              `json:"minKeep"`
+	Owner                string           `json:"owner"`
 }
 
 type SnapshotConfigs struct {
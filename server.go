@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+	log "github.com/sirupsen/logrus"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/snapshot"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/watch"
+	"github.com/utilitywarehouse/go-operational/op"
+)
+
+const (
+	// Timeout of the compute api call of the health check
+	apiCheckTimeout = 10 * time.Second
+	// How long the result of the compute api call of the health check is
+	// reused, so that health requests do not each make a call
+	apiCheckInterval = time.Minute
+)
+
+// healthChecks holds what the health checks of the operational endpoints look at
+type healthChecks struct {
+	watcher *watch.Watcher
+	gsc     *snapshot.GCPSnapClient
+	started time.Time
+	// Number of watch intervals without a finished watch cycle after which the
+	// watcher is unhealthy
+	cycleIntervals int
+	// Number of target intervals without a READY snapshot after which a disk
+	// is past its RPO
+	rpoIntervals float64
+
+	mu sync.Mutex
+	// When the compute api was last checked, and the result
+	apiChecked time.Time
+	apiErr     error
+}
+
+// checkCycle reports whether a watch cycle finished recently
+func (hc *healthChecks) checkCycle(cr *op.CheckResponse) {
+	last, failures := hc.watcher.LastCycle()
+	maxAge := time.Duration(hc.cycleIntervals*hc.watcher.WatchInterval) * time.Second
+
+	if last.IsZero() {
+		if time.Since(hc.started) > maxAge {
+			cr.Unhealthy(fmt.Sprintf("no watch cycle finished since starting %s ago", time.Since(hc.started).Round(time.Second)),
+				"check the logs for errors or a stuck cycle", "disks are not snapshotted")
+			return
+		}
+		cr.Healthy("waiting for the first watch cycle")
+		return
+	}
+	if age := time.Since(last); age > maxAge {
+		cr.Unhealthy(fmt.Sprintf("last watch cycle finished %s ago", age.Round(time.Second)),
+			"check the logs for errors or a stuck cycle", "disks are not snapshotted")
+		return
+	}
+	if failures > 0 {
+		cr.Degraded(fmt.Sprintf("last watch cycle finished at %s with %d failure(s)", last.Format(time.RFC3339), failures),
+			"check the logs for the failed targets, disks and operations")
+		return
+	}
+	cr.Healthy(fmt.Sprintf("last watch cycle finished at %s", last.Format(time.RFC3339)))
+}
+
+// checkAPI reports whether the compute api is reachable and authorized
+func (hc *healthChecks) checkAPI(cr *op.CheckResponse) {
+	if err := hc.apiAccess(); err != nil {
+		cr.Unhealthy(err.Error(), "check the credentials and permissions of the service account", "disks are not snapshotted")
+		return
+	}
+	cr.Healthy("compute api is reachable")
+}
+
+// apiAccess returns the result of the last compute api check, checking again
+// if it is older than apiCheckInterval
+func (hc *healthChecks) apiAccess() error {
+	hc.mu.Lock()
+	defer hc.mu.Unlock()
+	if !hc.apiChecked.IsZero() && time.Since(hc.apiChecked) < apiCheckInterval {
+		return hc.apiErr
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), apiCheckTimeout)
+	defer cancel()
+	hc.apiErr = hc.gsc.CheckAccess(ctx)
+	hc.apiChecked = time.Now()
+	return hc.apiErr
+}
+
+// checkRPO reports the disks whose newest READY snapshot is too old
+func (hc *healthChecks) checkRPO(cr *op.CheckResponse) {
+	past := hc.watcher.DisksPastRPO(time.Now(), hc.rpoIntervals)
+	if len(past) > 0 {
+		cr.Degraded(fmt.Sprintf("%d disk(s) past their RPO: %s", len(past), strings.Join(past, ", ")),
+			"check the logs and the operations of these disks")
+		return
+	}
+	cr.Healthy("all disks have a recent snapshot")
+}
+
+// revision is the revision the binary was built from, set at build time with
+// -ldflags "-X main.revision=..."
+var revision = "unknown"
+
+// serverConfig holds the settings of the HTTP server and the metadata reported
+// on /__/about
+type serverConfig struct {
+	listenAddress string
+	// Serve HTTPS if both are set
+	tlsCertFile string
+	tlsKeyFile  string
+
+	name       string
+	owner      string
+	ownerSlack string
+	links      []statusLink
+}
+
+type statusLink struct {
+	description string
+	url         string
+}
+
+// parseLinks parses a comma separated list of description=url pairs
+func parseLinks(value string) ([]statusLink, error) {
+	links := []statusLink{}
+	if value == "" {
+		return links, nil
+	}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+			return nil, fmt.Errorf("invalid link %q, expected description=url", pair)
+		}
+		links = append(links, statusLink{description: parts[0], url: parts[1]})
+	}
+	return links, nil
+}
+
+func startServer(sc serverConfig, hc *healthChecks) {
+	log.Info("starting HTTP endpoints on ", sc.listenAddress, " ...")
+
+	status := op.NewStatus(sc.name, "gcp-disk-snapshotter handles snapshot creation/deletion on gcp for a given set of disks").
+		AddOwner(sc.owner, sc.ownerSlack).
+		SetRevision(revision).
+		AddChecker("watch-cycle", hc.checkCycle).
+		AddChecker("compute-api", hc.checkAPI).
+		AddChecker("disk-rpo", hc.checkRPO).
+		Ready(hc.watcher.Ready)
+	for _, link := range sc.links {
+		status.AddLink(link.description, link.url)
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/__/", op.NewHandler(status))
+	mux.Handle("/metrics", promhttp.Handler())
+	mux.Handle("/api/", hc.watcher.APIHandler())
+
+	var err error
+	if sc.tlsCertFile != "" {
+		err = http.ListenAndServeTLS(sc.listenAddress, sc.tlsCertFile, sc.tlsKeyFile, mux)
+	} else {
+		err = http.ListenAndServe(sc.listenAddress, mux)
+	}
+	if err != nil {
+		log.Fatal("could not start HTTP router: ", err)
+	}
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseLinks(t *testing.T) {
+	for _, tc := range []struct {
+		name  string
+		value string
+		links []statusLink
+		err   string
+	}{
+		{
+			name:  "empty",
+			value: "",
+			links: []statusLink{},
+		},
+		{
+			name:  "one link",
+			value: "runbook=https://example.com/runbook",
+			links: []statusLink{{description: "runbook", url: "https://example.com/runbook"}},
+		},
+		{
+			name:  "several links with = in the url",
+			value: "runbook=https://example.com/runbook,dashboard=https://example.com/d?id=1",
+			links: []statusLink{
+				{description: "runbook", url: "https://example.com/runbook"},
+				{description: "dashboard", url: "https://example.com/d?id=1"},
+			},
+		},
+		{
+			name:  "missing separator",
+			value: "a",
+			err:   `invalid link "a", expected description=url`,
+		},
+		{
+			name:  "missing description",
+			value: "=u",
+			err:   `invalid link "=u", expected description=url`,
+		},
+		{
+			name:  "missing url",
+			value: "d=",
+			err:   `invalid link "d=", expected description=url`,
+		},
+		{
+			name:  "one malformed link among valid ones",
+			value: "runbook=https://example.com/runbook,a",
+			err:   `invalid link "a", expected description=url`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			links, err := parseLinks(tc.value)
+			if tc.err != "" {
+				assert.EqualError(t, err, tc.err)
+				return
+			}
+			assert.NoError(t, err)
+			assert.Equal(t, tc.links, links)
+		})
+	}
+}
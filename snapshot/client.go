@@ -2,9 +2,11 @@ package snapshot
 
 import (
 	"context"
+	"crypto/rand"
 	"encoding/json"
 	"fmt"
 	"net/http"
+	"sort"
 	"strings"
 	"time"
 
@@ -14,11 +16,13 @@ import (
 	"golang.org/x/oauth2/google"
 	compute "google.golang.org/api/compute/v1"
 
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/metrics"
 	"github.com/utilitywarehouse/gcp-disk-snapshotter/models"
 )
 
 const (
-	SnapshotterLabel      string = "gcp_disk_snapshotter"
+	SnapshotterLabel string = "gcp_disk_snapshotter"
+	// Default value of the SnapshotterLabel, identifying the owner of a snapshot
 	SnapshotterLabelValue string = "true"
 )
 
@@ -29,17 +33,21 @@ type GCPSnapClient struct {
 	Zones          []string
 	SnapPrefix     string
 	ComputeService compute.Service
+	// Optional, counts the retries of api calls
+	Metrics metrics.PrometheusInterface
 }
 
 type GCPSnapClientInterface interface {
-	GetDisksFromLabel(label *models.Label) ([]compute.Disk, error)
-	GetDisksFromDescription(label *models.Description) ([]compute.Disk, error)
-	ListSnapshots(diskSelfLink string) ([]*compute.Snapshot, error)
-	ListClientCreatedSnapshots(diskSelfLink string) ([]*compute.Snapshot, error)
-	CreateSnapshot(diskName, zone string) (string, error)
-	DeleteSnapshot(snapName string) (string, error)
-	GetZonalOperationStatus(operation, zone string) (string, error)
-	GetGlobalOperationStatus(operation string) (string, error)
+	GetDisksFromLabel(ctx context.Context, label *models.Label) ([]compute.Disk, error)
+	GetDisksFromSelector(ctx context.Context, selector *models.LabelSelector) ([]compute.Disk, error)
+	GetDisksFromDescription(ctx context.Context, label *models.Description) ([]compute.Disk, error)
+	ListClientCreatedSnapshots(ctx context.Context, owners []string) (SnapshotIndex, error)
+	CreateSnapshot(ctx context.Context, diskName, zone, owner string) (string, error)
+	CreateRegionalSnapshot(ctx context.Context, diskName, region, owner string) (string, error)
+	DeleteSnapshot(ctx context.Context, snapName string) (string, error)
+	WaitZonalOperation(ctx context.Context, operation, zone string) (string, error)
+	WaitRegionalOperation(ctx context.Context, operation, region string) (string, error)
+	WaitGlobalOperation(ctx context.Context, operation string) (string, error)
 }
 
 // Basic Init function for the snapshotter
@@ -63,8 +71,8 @@ func CreateGCPSnapClient(project, snapPrefix string, zones []string) *GCPSnapCli
 	}
 }
 
-// In case of a gcp link it returns the target (final part after /)
-func formatLinkString(in string) string {
+// FormatLinkString: In case of a gcp link it returns the target (final part after /)
+func FormatLinkString(in string) string {
 	if strings.ContainsAny(in, "/") {
 		elems := strings.Split(in, "/")
 		return elems[len(elems)-1]
@@ -72,47 +80,50 @@ func formatLinkString(in string) string {
 	return in
 }
 
-// GetDiskList: Returns a list of disks that contain one of the given labels
-func (gsc *GCPSnapClient) GetDisksFromLabel(label *models.Label) ([]compute.Disk, error) {
+// GetDisksFromLabel: Returns a list of disks that have the given label
+func (gsc *GCPSnapClient) GetDisksFromLabel(ctx context.Context, label *models.Label) ([]compute.Disk, error) {
+	return gsc.GetDisksFromSelector(ctx, &models.LabelSelector{
+		MatchLabels: map[string]string{label.Key: label.Value},
+	})
+}
+
+// GetDisksFromSelector: Returns a list of disks whose labels match the given selector
+func (gsc *GCPSnapClient) GetDisksFromSelector(ctx context.Context, selector *models.LabelSelector) ([]compute.Disk, error) {
 	disks := []compute.Disk{}
 
-	for _, zone := range gsc.Zones {
-		resp, err := gsc.ComputeService.Disks.List(gsc.Project, zone).Do()
-		if err != nil {
-			return disks, errors.Wrap(err, "error listing disks")
-		}
+	// Only the exact label matches are filtered server side, the selector is
+	// always checked in full on the returned disks
+	all, err := gsc.listDisks(ctx, selectorFilter(selector))
+	if err != nil {
+		return disks, err
+	}
 
-		for _, disk := range resp.Items {
-			if val, ok := disk.Labels[label.Key]; ok {
-				if label.Value == val {
-					disks = append(disks, *disk)
-				}
-			}
+	for _, disk := range all {
+		if selector.Matches(disk.Labels) {
+			disks = append(disks, *disk)
 		}
 	}
 
 	return disks, nil
 }
 
-func (gsc *GCPSnapClient) GetDisksFromDescription(desc *models.Description) ([]compute.Disk, error) {
+func (gsc *GCPSnapClient) GetDisksFromDescription(ctx context.Context, desc *models.Description) ([]compute.Disk, error) {
 	disks := []compute.Disk{}
 
-	for _, zone := range gsc.Zones {
-		resp, err := gsc.ComputeService.Disks.List(gsc.Project, zone).Do()
-		if err != nil {
-			return disks, errors.Wrap(err, "error listing disks")
-		}
+	all, err := gsc.listDisks(ctx, "")
+	if err != nil {
+		return disks, err
+	}
 
-		for _, disk := range resp.Items {
-			var dObj map[string]string
-			if err = json.Unmarshal([]byte(disk.Description), &dObj); err != nil {
-				log.Debug("Skipping: error unmarshalling disk description to map: ", err)
-				continue
-			}
-			if val, ok := dObj[desc.Key]; ok {
-				if desc.Value == val {
-					disks = append(disks, *disk)
-				}
+	for _, disk := range all {
+		var dObj map[string]string
+		if err = json.Unmarshal([]byte(disk.Description), &dObj); err != nil {
+			log.Debug("Skipping: error unmarshalling disk description to map: ", err)
+			continue
+		}
+		if val, ok := dObj[desc.Key]; ok {
+			if desc.Value == val {
+				disks = append(disks, *disk)
 			}
 		}
 	}
@@ -120,93 +131,247 @@ func (gsc *GCPSnapClient) GetDisksFromDescription(desc *models.Description) ([]c
 	return disks, nil
 }
 
-// ListSnapshots: Lists Snapshots for a given disk
-func (gsc *GCPSnapClient) ListSnapshots(diskSelfLink string) ([]*compute.Snapshot, error) {
-	var snapshots []*compute.Snapshot
+// listDisks: Lists the zonal and regional disks of the project that match the given
+// filter expression, using the aggregated list, and returns the ones that live in the
+// allowed zones. Regional disks are allowed if any of their replica zones is. All zones
+// are allowed when no zones are configured.
+func (gsc *GCPSnapClient) listDisks(ctx context.Context, filter string) ([]*compute.Disk, error) {
+	disks := []*compute.Disk{}
 
-	req := gsc.ComputeService.Snapshots.List(gsc.Project)
+	req := gsc.ComputeService.Disks.AggregatedList(gsc.Project)
+	if filter != "" {
+		req = req.Filter(filter)
+	}
 
-	err := req.Pages(context.Background(), func(page *compute.SnapshotList) error {
-		for _, snap := range page.Items {
-			// If not created by the snapshotter just ignore
-			if val, ok := snap.Labels[SnapshotterLabel]; ok {
-				if val != SnapshotterLabelValue {
+	err := gsc.listPages(ctx, "Disks.AggregatedList", func(pageToken string) (string, error) {
+		page, err := req.PageToken(pageToken).Context(ctx).Do()
+		if err != nil {
+			return "", err
+		}
+		for scope, scoped := range page.Items {
+			for _, disk := range scoped.Disks {
+				if !gsc.diskAllowed(disk) {
+					log.Debug("Skipping disk ", disk.Name, " in ", scope, ": zone not allowed")
 					continue
 				}
-			} else {
-				continue
+				disks = append(disks, disk)
 			}
+		}
+		return page.NextPageToken, nil
+	})
+	if err != nil {
+		return disks, errors.Wrap(err, "error listing disks")
+	}
 
-			// Skip if snapshot is not from the requested disk
-			if snap.SourceDisk != diskSelfLink {
-				continue
+	return disks, nil
+}
+
+// selectorFilter returns a filter expression for the list api that matches the exact
+// labels of the selector
+func selectorFilter(selector *models.LabelSelector) string {
+	keys := []string{}
+	for k := range selector.MatchLabels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	exprs := []string{}
+	for _, k := range keys {
+		exprs = append(exprs, fmt.Sprintf("(labels.%s = %q)", k, selector.MatchLabels[k]))
+	}
+	return strings.Join(exprs, " ")
+}
+
+func (gsc *GCPSnapClient) diskAllowed(disk *compute.Disk) bool {
+	if disk.Region != "" {
+		for _, zone := range disk.ReplicaZones {
+			if gsc.zoneAllowed(zone) {
+				return true
 			}
+		}
+		return false
+	}
+	return gsc.zoneAllowed(disk.Zone)
+}
 
-			snapshots = append(snapshots, snap)
+func (gsc *GCPSnapClient) zoneAllowed(zone string) bool {
+	if len(gsc.Zones) == 0 {
+		return true
+	}
+	zone = FormatLinkString(zone)
+	for _, z := range gsc.Zones {
+		if z == zone {
+			return true
 		}
-		return nil
+	}
+	return false
+}
+
+// CheckAccess: Makes a minimal snapshot list call to check that the compute api is reachable and
+// that the client is authorized for the project
+func (gsc *GCPSnapClient) CheckAccess(ctx context.Context) error {
+	err := gsc.call(ctx, "Snapshots.List", func() error {
+		_, err := gsc.ComputeService.Snapshots.List(gsc.Project).MaxResults(1).Fields("id").Context(ctx).Do()
+		return err
 	})
 	if err != nil {
-		return nil, errors.Wrap(err, "error requesting snapshots list:")
+		return errors.Wrap(err, "error listing snapshots:")
 	}
+	return nil
+}
+
+// SnapshotIndex holds snapshots by the self link of their source disk
+type SnapshotIndex map[string][]*compute.Snapshot
 
-	return snapshots, nil
+// Owned returns the snapshots of the given disk that belong to the given owner
+func (i SnapshotIndex) Owned(diskSelfLink, owner string) []*compute.Snapshot {
+	snaps := []*compute.Snapshot{}
+	for _, snap := range i[diskSelfLink] {
+		if snap.Labels[SnapshotterLabel] == owner {
+			snaps = append(snaps, snap)
+		}
+	}
+	return snaps
 }
 
-// ListClientCreatedSnapshots: Lists snapshots for a given disk that were created by the client,
-// meaning that they have the SnapshotterLabel
-func (gsc *GCPSnapClient) ListClientCreatedSnapshots(diskSelfLink string) ([]*compute.Snapshot, error) {
-	snaps, err := gsc.ListSnapshots(diskSelfLink)
-	if err != nil {
-		return nil, err
+// ListClientCreatedSnapshots: Lists all the snapshots of the project that were created by the
+// client for any of the given owners, meaning that their SnapshotterLabel has one of the owners
+// as value, and indexes them by source disk
+func (gsc *GCPSnapClient) ListClientCreatedSnapshots(ctx context.Context, owners []string) (SnapshotIndex, error) {
+	index := SnapshotIndex{}
+
+	exprs := []string{}
+	owned := map[string]bool{}
+	for _, owner := range owners {
+		exprs = append(exprs, fmt.Sprintf("(labels.%s = %q)", SnapshotterLabel, owner))
+		owned[owner] = true
 	}
+	req := gsc.ComputeService.Snapshots.List(gsc.Project).Filter(strings.Join(exprs, " OR "))
 
-	res := []*compute.Snapshot{}
-	for _, snap := range snaps {
-		if val, ok := snap.Labels[SnapshotterLabel]; ok {
-			if SnapshotterLabelValue == val {
-				res = append(res, snap)
+	err := gsc.listPages(ctx, "Snapshots.List", func(pageToken string) (string, error) {
+		page, err := req.PageToken(pageToken).Context(ctx).Do()
+		if err != nil {
+			return "", err
+		}
+		for _, snap := range page.Items {
+			// If not created by the snapshotter just ignore
+			if val, ok := snap.Labels[SnapshotterLabel]; !ok || !owned[val] {
 				continue
 			}
+			index[snap.SourceDisk] = append(index[snap.SourceDisk], snap)
 		}
+		return page.NextPageToken, nil
+	})
+	if err != nil {
+		return nil, errors.Wrap(err, "error requesting snapshots list:")
 	}
 
-	return res, nil
+	return index, nil
 }
 
-// CreateSnapshot: Gets a disk name and a zone, issues a create snapshot command to api
-// and returns a link to the create snapshot operation
-func (gsc *GCPSnapClient) CreateSnapshot(diskName, zone string) (string, error) {
+// CreateSnapshot: Gets a disk name, a zone and the owner to label the snapshot with, issues a
+// create snapshot command to api and returns a link to the create snapshot operation
+func (gsc *GCPSnapClient) CreateSnapshot(ctx context.Context, diskName, zone, owner string) (string, error) {
 	// format zone if link
-	zn := formatLinkString(zone)
+	zn := FormatLinkString(zone)
+
+	// The same request id is used for all attempts, so that a retried request
+	// does not create a second snapshot
+	req := gsc.ComputeService.Disks.CreateSnapshot(gsc.Project, zn, diskName, gsc.newSnapshot(diskName, owner))
+	if id := newRequestID(); id != "" {
+		req = req.RequestId(id)
+	}
+	var resp *compute.Operation
+	err := gsc.call(ctx, "Disks.CreateSnapshot", func() (err error) {
+		resp, err = req.Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "error taking disk snapshot:")
+	}
+
+	return resp.SelfLink, nil
+}
+
+// CreateRegionalSnapshot: Gets a regional disk name, its region and the owner to label the snapshot
+// with, issues a create snapshot command to api and returns a link to the create snapshot operation
+func (gsc *GCPSnapClient) CreateRegionalSnapshot(ctx context.Context, diskName, region, owner string) (string, error) {
+	// format region if link
+	rn := FormatLinkString(region)
 
+	req := gsc.ComputeService.RegionDisks.CreateSnapshot(gsc.Project, rn, diskName, gsc.newSnapshot(diskName, owner))
+	if id := newRequestID(); id != "" {
+		req = req.RequestId(id)
+	}
+	var resp *compute.Operation
+	err := gsc.call(ctx, "RegionDisks.CreateSnapshot", func() (err error) {
+		resp, err = req.Context(ctx).Do()
+		return err
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "error taking regional disk snapshot:")
+	}
+
+	return resp.SelfLink, nil
+}
+
+// newSnapshot returns the snapshot to create for the given disk and owner
+func (gsc *GCPSnapClient) newSnapshot(diskName, owner string) *compute.Snapshot {
 	// lowercase letters, numeric characters, underscores and dashes, at most 63 characters long
 	snapLabels := map[string]string{
-		SnapshotterLabel: SnapshotterLabelValue,
+		SnapshotterLabel: owner,
+	}
+
+	return &compute.Snapshot{
+		Description: fmt.Sprintf("Snapshot of %s", diskName),
+		Name:        snapshotName(gsc.SnapPrefix, diskName, time.Now(), randomSuffix()),
+		Labels:      snapLabels,
 	}
+}
 
+// Maximum length of a snapshot name
+const maxSnapshotNameLength = 63
+
+// snapshotName returns the name of a snapshot of the given disk taken at the
+// given time. The suffix tells apart snapshots of the same disk taken within
+// the same second, for example by targets of different owners.
+func snapshotName(prefix, diskName string, t time.Time, suffix string) string {
 	// Name must match regex '(?:[a-z](?:[-a-z0-9]{0,61}[a-z0-9])?)'
 	// Note: kubernetes creates pvs with names like: kubernetes-dynamic-pvc-828cdc8a-4f85-11e8-a7bc-42010a16140a
 	// that are 60 chars and so snapshots exceed the 63 chars long with the added suffix.
-	// Let's just trim `kubernetes-dynamic-` from the name
+	// Let's just trim `kubernetes-dynamic-` from the name, and the end of names that are still too long
 	name := strings.TrimPrefix(diskName, "kubernetes-dynamic-")
-	snapshot := &compute.Snapshot{
-		Description: fmt.Sprintf("Snapshot of %s", diskName),
-		Name:        fmt.Sprintf("%s%s-%s", gsc.SnapPrefix, name, time.Now().Format("20060102150405")),
-		Labels:      snapLabels,
+	end := fmt.Sprintf("-%s-%s", t.Format("20060102150405"), suffix)
+	if max := maxSnapshotNameLength - len(prefix) - len(end); len(name) > max {
+		if max < 0 {
+			max = 0
+		}
+		name = strings.TrimRight(name[:max], "-")
 	}
+	return prefix + name + end
+}
 
-	resp, err := gsc.ComputeService.Disks.CreateSnapshot(gsc.Project, zn, diskName, snapshot).Do()
-	if err != nil {
-		return "", errors.Wrap(err, "error taking disk snapshot:")
+// randomSuffix returns 4 random hex characters
+func randomSuffix() string {
+	b := make([]byte, 2)
+	if _, err := rand.Read(b); err != nil {
+		log.Error("error generating snapshot name suffix: ", err)
+		return fmt.Sprintf("%04x", time.Now().UnixNano()&0xffff)
 	}
-
-	return resp.SelfLink, nil
+	return fmt.Sprintf("%x", b)
 }
 
 // DeleteSnapshot: Gets a snapshot name and issues a delete. Returns a link to the delete operation
-func (gsc *GCPSnapClient) DeleteSnapshot(snapName string) (string, error) {
-	resp, err := gsc.ComputeService.Snapshots.Delete(gsc.Project, snapName).Do()
+func (gsc *GCPSnapClient) DeleteSnapshot(ctx context.Context, snapName string) (string, error) {
+	req := gsc.ComputeService.Snapshots.Delete(gsc.Project, snapName)
+	if id := newRequestID(); id != "" {
+		req = req.RequestId(id)
+	}
+	var resp *compute.Operation
+	err := gsc.call(ctx, "Snapshots.Delete", func() (err error) {
+		resp, err = req.Context(ctx).Do()
+		return err
+	})
 	if err != nil {
 		return "", errors.Wrap(err, "error deleting snapshot:")
 	}
@@ -227,26 +392,74 @@ func parseOperationOut(operation *compute.Operation) (string, error) {
 	return status, nil
 }
 
-func (gsc *GCPSnapClient) GetZonalOperationStatus(operation, zone string) (string, error) {
+// operationWaitDeadline bounds a single wait call. The api returns by itself
+// after about 2 minutes, with the operation possibly still in progress.
+var operationWaitDeadline = 3 * time.Minute
+
+// waitOperation calls the given wait method of the api, that blocks until the
+// operation is done or the wait deadline is reached, and returns the
+// operation. Reaching the deadline of a single call is not an error, the
+// operation is returned as still running.
+func (gsc *GCPSnapClient) waitOperation(ctx context.Context, method string, wait func(ctx context.Context) (*compute.Operation, error)) (*compute.Operation, error) {
+	var op *compute.Operation
+	err := gsc.call(ctx, method, func() (err error) {
+		waitCtx, cancel := context.WithTimeout(ctx, operationWaitDeadline)
+		defer cancel()
+		op, err = wait(waitCtx)
+		if err != nil && waitCtx.Err() != nil && ctx.Err() == nil {
+			op = &compute.Operation{Status: "RUNNING"}
+			return nil
+		}
+		return err
+	})
+	return op, err
+}
+
+// WaitZonalOperation: Waits for a zonal operation to be done, or for the wait deadline, and returns
+// its status
+func (gsc *GCPSnapClient) WaitZonalOperation(ctx context.Context, operation, zone string) (string, error) {
 	// Format in case of link
-	operation = formatLinkString(operation)
-	zone = formatLinkString(zone)
+	operation = FormatLinkString(operation)
+	zone = FormatLinkString(zone)
 
-	op, err := gsc.ComputeService.ZoneOperations.Get(gsc.Project, zone, operation).Do()
+	op, err := gsc.waitOperation(ctx, "ZoneOperations.Wait", func(ctx context.Context) (*compute.Operation, error) {
+		return gsc.ComputeService.ZoneOperations.Wait(gsc.Project, zone, operation).Context(ctx).Do()
+	})
 	if err != nil {
-		return "", errors.Wrap(err, "error getting zonal operation:")
+		return "", errors.Wrap(err, "error waiting for zonal operation:")
 	}
 
 	return parseOperationOut(op)
 }
 
-func (gsc *GCPSnapClient) GetGlobalOperationStatus(operation string) (string, error) {
+// WaitRegionalOperation: Waits for a regional operation to be done, or for the wait deadline, and
+// returns its status
+func (gsc *GCPSnapClient) WaitRegionalOperation(ctx context.Context, operation, region string) (string, error) {
 	// Format in case of link
-	operation = formatLinkString(operation)
+	operation = FormatLinkString(operation)
+	region = FormatLinkString(region)
+
+	op, err := gsc.waitOperation(ctx, "RegionOperations.Wait", func(ctx context.Context) (*compute.Operation, error) {
+		return gsc.ComputeService.RegionOperations.Wait(gsc.Project, region, operation).Context(ctx).Do()
+	})
+	if err != nil {
+		return "", errors.Wrap(err, "error waiting for regional operation:")
+	}
 
-	op, err := gsc.ComputeService.GlobalOperations.Get(gsc.Project, operation).Do()
+	return parseOperationOut(op)
+}
+
+// WaitGlobalOperation: Waits for a global operation to be done, or for the wait deadline, and
+// returns its status
+func (gsc *GCPSnapClient) WaitGlobalOperation(ctx context.Context, operation string) (string, error) {
+	// Format in case of link
+	operation = FormatLinkString(operation)
+
+	op, err := gsc.waitOperation(ctx, "GlobalOperations.Wait", func(ctx context.Context) (*compute.Operation, error) {
+		return gsc.ComputeService.GlobalOperations.Wait(gsc.Project, operation).Context(ctx).Do()
+	})
 	if err != nil {
-		return "", errors.Wrap(err, "error getting global operation:")
+		return "", errors.Wrap(err, "error waiting for global operation:")
 	}
 
 	return parseOperationOut(op)
@@ -0,0 +1,152 @@
+package snapshot
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/models"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestWaitOperation(t *testing.T) {
+	gsc := &GCPSnapClient{}
+
+	defer func(deadline time.Duration) { operationWaitDeadline = deadline }(operationWaitDeadline)
+	operationWaitDeadline = 10 * time.Millisecond
+
+	// Reaching the deadline of a wait returns the operation as still running
+	op, err := gsc.waitOperation(context.Background(), "Test.Wait", func(ctx context.Context) (*compute.Operation, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "RUNNING", op.Status)
+
+	// Unless the caller is done waiting
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = gsc.waitOperation(ctx, "Test.Wait", func(ctx context.Context) (*compute.Operation, error) {
+		<-ctx.Done()
+		return nil, ctx.Err()
+	})
+	assert.Equal(t, context.Canceled, err)
+
+	op, err = gsc.waitOperation(context.Background(), "Test.Wait", func(ctx context.Context) (*compute.Operation, error) {
+		return &compute.Operation{Status: "DONE"}, nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, "DONE", op.Status)
+}
+
+func TestSelectorFilter(t *testing.T) {
+	for _, tc := range []struct {
+		name     string
+		selector *models.LabelSelector
+		filter   string
+	}{
+		{
+			name:     "no labels",
+			selector: &models.LabelSelector{},
+			filter:   "",
+		},
+		{
+			name:     "one label",
+			selector: &models.LabelSelector{MatchLabels: map[string]string{"env": "prod"}},
+			filter:   `(labels.env = "prod")`,
+		},
+		{
+			name:     "labels sorted by key",
+			selector: &models.LabelSelector{MatchLabels: map[string]string{"tier": "db", "app": "x", "env": "prod"}},
+			filter:   `(labels.app = "x") (labels.env = "prod") (labels.tier = "db")`,
+		},
+		{
+			name:     "values quoted",
+			selector: &models.LabelSelector{MatchLabels: map[string]string{"name": `a"b`}},
+			filter:   `(labels.name = "a\"b")`,
+		},
+		{
+			name: "expressions left to the client",
+			selector: &models.LabelSelector{
+				MatchLabels:      map[string]string{"env": "prod"},
+				MatchExpressions: []*models.LabelSelectorRequirement{{Key: "tier", Operator: "In", Values: []string{"db"}}},
+			},
+			filter: `(labels.env = "prod")`,
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			assert.Equal(t, tc.filter, selectorFilter(tc.selector))
+		})
+	}
+}
+
+func TestDiskAllowed(t *testing.T) {
+	zonal := func(zone string) *compute.Disk {
+		return &compute.Disk{Name: "zonal", Zone: "https://www.googleapis.com/compute/v1/projects/p/zones/" + zone}
+	}
+	regional := func(zones ...string) *compute.Disk {
+		links := []string{}
+		for _, z := range zones {
+			links = append(links, "https://www.googleapis.com/compute/v1/projects/p/zones/"+z)
+		}
+		return &compute.Disk{Name: "regional", Region: "https://www.googleapis.com/compute/v1/projects/p/regions/europe-west2", ReplicaZones: links}
+	}
+
+	for _, tc := range []struct {
+		name    string
+		zones   []string
+		disk    *compute.Disk
+		allowed bool
+	}{
+		{"all zones allowed without zones", nil, zonal("europe-west2-a"), true},
+		{"regional disk allowed without zones", nil, regional("europe-west2-a", "europe-west2-b"), true},
+		{"zonal disk in allowed zone", []string{"europe-west2-a"}, zonal("europe-west2-a"), true},
+		{"zonal disk in other zone", []string{"europe-west2-a"}, zonal("europe-west2-c"), false},
+		{"zonal disk with zone name", []string{"europe-west2-a"}, &compute.Disk{Zone: "europe-west2-a"}, true},
+		{"regional disk with an allowed replica zone", []string{"europe-west2-b"}, regional("europe-west2-a", "europe-west2-b"), true},
+		{"regional disk without an allowed replica zone", []string{"europe-west2-c"}, regional("europe-west2-a", "europe-west2-b"), false},
+		{"regional disk without replica zones", []string{"europe-west2-a"}, regional(), false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			gsc := &GCPSnapClient{Zones: tc.zones}
+			assert.Equal(t, tc.allowed, gsc.diskAllowed(tc.disk))
+		})
+	}
+}
+
+func TestSnapshotName(t *testing.T) {
+	now := time.Date(2020, 7, 1, 2, 30, 0, 0, time.UTC)
+	for _, tc := range []struct {
+		name     string
+		prefix   string
+		disk     string
+		expected string
+	}{
+		{"short name", "", "data", "data-20200701023000-ab12"},
+		{"with prefix", "prod-", "data", "prod-data-20200701023000-ab12"},
+		{
+			"kubernetes pv",
+			"",
+			"kubernetes-dynamic-pvc-828cdc8a-4f85-11e8-a7bc-42010a16140a",
+			"pvc-828cdc8a-4f85-11e8-a7bc-42010a16140a-20200701023000-ab12",
+		},
+		{
+			"trimmed to 63 characters",
+			"compliance-",
+			"kubernetes-dynamic-pvc-828cdc8a-4f85-11e8-a7bc-42010a16140a",
+			"compliance-pvc-828cdc8a-4f85-11e8-a7bc-4201-20200701023000-ab12",
+		},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			name := snapshotName(tc.prefix, tc.disk, now, "ab12")
+			assert.Equal(t, tc.expected, name)
+			assert.LessOrEqual(t, len(name), maxSnapshotNameLength)
+		})
+	}
+
+	// Snapshots of the same disk taken in the same second, for example for
+	// targets of different owners, get a random suffix
+	gsc := &GCPSnapClient{}
+	assert.Regexp(t, `^data-[0-9]{14}-[0-9a-f]{4}$`, gsc.newSnapshot("data", "compliance").Name)
+}
@@ -5,11 +5,12 @@
 package snapshot
 
 import (
+	context "context"
 	reflect "reflect"
 
 	gomock "github.com/golang/mock/gomock"
 	models "github.com/utilitywarehouse/gcp-disk-snapshotter/models"
-	compute "google.golang.org/api/compute/v1"
+	v1 "google.golang.org/api/compute/v1"
 )
 
 // MockGCPSnapClientInterface is a mock of GCPSnapClientInterface interface.
@@ -35,122 +36,152 @@ func (m *MockGCPSnapClientInterface) EXPECT() *MockGCPSnapClientInterfaceMockRec
 	return m.recorder
 }
 
+// CreateRegionalSnapshot mocks base method.
+func (m *MockGCPSnapClientInterface) CreateRegionalSnapshot(ctx context.Context, diskName, region, owner string) (string, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "CreateRegionalSnapshot", ctx, diskName, region, owner)
+	ret0, _ := ret[0].(string)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// CreateRegionalSnapshot indicates an expected call of CreateRegionalSnapshot.
+func (mr *MockGCPSnapClientInterfaceMockRecorder) CreateRegionalSnapshot(ctx, diskName, region, owner interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateRegionalSnapshot", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).CreateRegionalSnapshot), ctx, diskName, region, owner)
+}
+
 // CreateSnapshot mocks base method.
-func (m *MockGCPSnapClientInterface) CreateSnapshot(diskName, zone string) (string, error) {
+func (m *MockGCPSnapClientInterface) CreateSnapshot(ctx context.Context, diskName, zone, owner string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "CreateSnapshot", diskName, zone)
+	ret := m.ctrl.Call(m, "CreateSnapshot", ctx, diskName, zone, owner)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // CreateSnapshot indicates an expected call of CreateSnapshot.
-func (mr *MockGCPSnapClientInterfaceMockRecorder) CreateSnapshot(diskName, zone interface{}) *gomock.Call {
+func (mr *MockGCPSnapClientInterfaceMockRecorder) CreateSnapshot(ctx, diskName, zone, owner interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSnapshot", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).CreateSnapshot), diskName, zone)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "CreateSnapshot", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).CreateSnapshot), ctx, diskName, zone, owner)
 }
 
 // DeleteSnapshot mocks base method.
-func (m *MockGCPSnapClientInterface) DeleteSnapshot(snapName string) (string, error) {
+func (m *MockGCPSnapClientInterface) DeleteSnapshot(ctx context.Context, snapName string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "DeleteSnapshot", snapName)
+	ret := m.ctrl.Call(m, "DeleteSnapshot", ctx, snapName)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // DeleteSnapshot indicates an expected call of DeleteSnapshot.
-func (mr *MockGCPSnapClientInterfaceMockRecorder) DeleteSnapshot(snapName interface{}) *gomock.Call {
+func (mr *MockGCPSnapClientInterfaceMockRecorder) DeleteSnapshot(ctx, snapName interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteSnapshot", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).DeleteSnapshot), snapName)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "DeleteSnapshot", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).DeleteSnapshot), ctx, snapName)
 }
 
 // GetDisksFromDescription mocks base method.
-func (m *MockGCPSnapClientInterface) GetDisksFromDescription(label *models.Description) ([]compute.Disk, error) {
+func (m *MockGCPSnapClientInterface) GetDisksFromDescription(ctx context.Context, label *models.Description) ([]v1.Disk, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetDisksFromDescription", label)
-	ret0, _ := ret[0].([]compute.Disk)
+	ret := m.ctrl.Call(m, "GetDisksFromDescription", ctx, label)
+	ret0, _ := ret[0].([]v1.Disk)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetDisksFromDescription indicates an expected call of GetDisksFromDescription.
-func (mr *MockGCPSnapClientInterfaceMockRecorder) GetDisksFromDescription(label interface{}) *gomock.Call {
+func (mr *MockGCPSnapClientInterfaceMockRecorder) GetDisksFromDescription(ctx, label interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDisksFromDescription", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).GetDisksFromDescription), label)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDisksFromDescription", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).GetDisksFromDescription), ctx, label)
 }
 
 // GetDisksFromLabel mocks base method.
-func (m *MockGCPSnapClientInterface) GetDisksFromLabel(label *models.Label) ([]compute.Disk, error) {
+func (m *MockGCPSnapClientInterface) GetDisksFromLabel(ctx context.Context, label *models.Label) ([]v1.Disk, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetDisksFromLabel", label)
-	ret0, _ := ret[0].([]compute.Disk)
+	ret := m.ctrl.Call(m, "GetDisksFromLabel", ctx, label)
+	ret0, _ := ret[0].([]v1.Disk)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
 // GetDisksFromLabel indicates an expected call of GetDisksFromLabel.
-func (mr *MockGCPSnapClientInterfaceMockRecorder) GetDisksFromLabel(label interface{}) *gomock.Call {
+func (mr *MockGCPSnapClientInterfaceMockRecorder) GetDisksFromLabel(ctx, label interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDisksFromLabel", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).GetDisksFromLabel), label)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDisksFromLabel", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).GetDisksFromLabel), ctx, label)
 }
 
-// GetGlobalOperationStatus mocks base method.
-func (m *MockGCPSnapClientInterface) GetGlobalOperationStatus(operation string) (string, error) {
+// GetDisksFromSelector mocks base method.
+func (m *MockGCPSnapClientInterface) GetDisksFromSelector(ctx context.Context, selector *models.LabelSelector) ([]v1.Disk, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetGlobalOperationStatus", operation)
-	ret0, _ := ret[0].(string)
+	ret := m.ctrl.Call(m, "GetDisksFromSelector", ctx, selector)
+	ret0, _ := ret[0].([]v1.Disk)
+	ret1, _ := ret[1].(error)
+	return ret0, ret1
+}
+
+// GetDisksFromSelector indicates an expected call of GetDisksFromSelector.
+func (mr *MockGCPSnapClientInterfaceMockRecorder) GetDisksFromSelector(ctx, selector interface{}) *gomock.Call {
+	mr.mock.ctrl.T.Helper()
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetDisksFromSelector", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).GetDisksFromSelector), ctx, selector)
+}
+
+// ListClientCreatedSnapshots mocks base method.
+func (m *MockGCPSnapClientInterface) ListClientCreatedSnapshots(ctx context.Context, owners []string) (SnapshotIndex, error) {
+	m.ctrl.T.Helper()
+	ret := m.ctrl.Call(m, "ListClientCreatedSnapshots", ctx, owners)
+	ret0, _ := ret[0].(SnapshotIndex)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetGlobalOperationStatus indicates an expected call of GetGlobalOperationStatus.
-func (mr *MockGCPSnapClientInterfaceMockRecorder) GetGlobalOperationStatus(operation interface{}) *gomock.Call {
+// ListClientCreatedSnapshots indicates an expected call of ListClientCreatedSnapshots.
+func (mr *MockGCPSnapClientInterfaceMockRecorder) ListClientCreatedSnapshots(ctx, owners interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetGlobalOperationStatus", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).GetGlobalOperationStatus), operation)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListClientCreatedSnapshots", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).ListClientCreatedSnapshots), ctx, owners)
 }
 
-// GetZonalOperationStatus mocks base method.
-func (m *MockGCPSnapClientInterface) GetZonalOperationStatus(operation, zone string) (string, error) {
+// WaitGlobalOperation mocks base method.
+func (m *MockGCPSnapClientInterface) WaitGlobalOperation(ctx context.Context, operation string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "GetZonalOperationStatus", operation, zone)
+	ret := m.ctrl.Call(m, "WaitGlobalOperation", ctx, operation)
 	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// GetZonalOperationStatus indicates an expected call of GetZonalOperationStatus.
-func (mr *MockGCPSnapClientInterfaceMockRecorder) GetZonalOperationStatus(operation, zone interface{}) *gomock.Call {
+// WaitGlobalOperation indicates an expected call of WaitGlobalOperation.
+func (mr *MockGCPSnapClientInterfaceMockRecorder) WaitGlobalOperation(ctx, operation interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "GetZonalOperationStatus", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).GetZonalOperationStatus), operation, zone)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WaitGlobalOperation", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).WaitGlobalOperation), ctx, operation)
 }
 
-// ListClientCreatedSnapshots mocks base method.
-func (m *MockGCPSnapClientInterface) ListClientCreatedSnapshots(diskSelfLink string) ([]*compute.Snapshot, error) {
+// WaitRegionalOperation mocks base method.
+func (m *MockGCPSnapClientInterface) WaitRegionalOperation(ctx context.Context, operation, region string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ListClientCreatedSnapshots", diskSelfLink)
-	ret0, _ := ret[0].([]*compute.Snapshot)
+	ret := m.ctrl.Call(m, "WaitRegionalOperation", ctx, operation, region)
+	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// ListClientCreatedSnapshots indicates an expected call of ListClientCreatedSnapshots.
-func (mr *MockGCPSnapClientInterfaceMockRecorder) ListClientCreatedSnapshots(diskSelfLink interface{}) *gomock.Call {
+// WaitRegionalOperation indicates an expected call of WaitRegionalOperation.
+func (mr *MockGCPSnapClientInterfaceMockRecorder) WaitRegionalOperation(ctx, operation, region interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListClientCreatedSnapshots", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).ListClientCreatedSnapshots), diskSelfLink)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WaitRegionalOperation", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).WaitRegionalOperation), ctx, operation, region)
 }
 
-// ListSnapshots mocks base method.
-func (m *MockGCPSnapClientInterface) ListSnapshots(diskSelfLink string) ([]*compute.Snapshot, error) {
+// WaitZonalOperation mocks base method.
+func (m *MockGCPSnapClientInterface) WaitZonalOperation(ctx context.Context, operation, zone string) (string, error) {
 	m.ctrl.T.Helper()
-	ret := m.ctrl.Call(m, "ListSnapshots", diskSelfLink)
-	ret0, _ := ret[0].([]*compute.Snapshot)
+	ret := m.ctrl.Call(m, "WaitZonalOperation", ctx, operation, zone)
+	ret0, _ := ret[0].(string)
 	ret1, _ := ret[1].(error)
 	return ret0, ret1
 }
 
-// ListSnapshots indicates an expected call of ListSnapshots.
-func (mr *MockGCPSnapClientInterfaceMockRecorder) ListSnapshots(diskSelfLink interface{}) *gomock.Call {
+// WaitZonalOperation indicates an expected call of WaitZonalOperation.
+func (mr *MockGCPSnapClientInterfaceMockRecorder) WaitZonalOperation(ctx, operation, zone interface{}) *gomock.Call {
 	mr.mock.ctrl.T.Helper()
-	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "ListSnapshots", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).ListSnapshots), diskSelfLink)
+	return mr.mock.ctrl.RecordCallWithMethodType(mr.mock, "WaitZonalOperation", reflect.TypeOf((*MockGCPSnapClientInterface)(nil).WaitZonalOperation), ctx, operation, zone)
 }
@@ -0,0 +1,174 @@
+package snapshot
+
+import (
+	"context"
+	"crypto/rand"
+	"fmt"
+	"math"
+	mrand "math/rand"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/pkg/errors"
+	log "github.com/sirupsen/logrus"
+	"google.golang.org/api/googleapi"
+)
+
+// Backoff of the retried api calls. The delay before every retry is picked at
+// random up to an exponentially growing ceiling, unless the response asks to
+// retry after a given time.
+var (
+	apiAttempts      = 5
+	apiBackoffBase   = 1 * time.Second
+	apiBackoffMax    = 30 * time.Second
+	apiRetryAfterMax = 5 * time.Minute
+)
+
+// call calls fn until it succeeds, returns a permanent error, or apiAttempts
+// is reached, and returns the last error. Every attempt is counted and timed
+// per method, and retries per method and reason.
+func (gsc *GCPSnapClient) call(ctx context.Context, method string, fn func() error) error {
+	for attempt := 1; ; attempt++ {
+		start := time.Now()
+		err := fn()
+		if err == nil {
+			if gsc.Metrics != nil {
+				gsc.Metrics.ObserveAPICall(method, "ok", time.Since(start))
+			}
+			return nil
+		}
+		reason, retryable := classify(err)
+		if gsc.Metrics != nil {
+			gsc.Metrics.ObserveAPICall(method, reason, time.Since(start))
+		}
+		if !retryable || attempt >= apiAttempts || ctx.Err() != nil {
+			return err
+		}
+
+		delay := backoff(attempt)
+		if after, ok := retryAfter(err); ok {
+			delay = after
+		}
+		log.Debug(method, " failed (", reason, "), retrying in ", delay, ": ", err)
+		if gsc.Metrics != nil {
+			gsc.Metrics.UpdateAPIRetryCount(method, reason)
+		}
+
+		select {
+		case <-time.After(delay):
+		case <-ctx.Done():
+			return err
+		}
+	}
+}
+
+// listPages calls list with the token of every page of a listing, starting
+// with the first one, until it returns no next page token. Every page is a call
+// of its own: it is counted, timed and retried separately.
+func (gsc *GCPSnapClient) listPages(ctx context.Context, method string, list func(pageToken string) (string, error)) error {
+	pageToken := ""
+	for {
+		var next string
+		err := gsc.call(ctx, method, func() error {
+			var err error
+			next, err = list(pageToken)
+			return err
+		})
+		if err != nil {
+			return err
+		}
+		if next == "" {
+			return nil
+		}
+		pageToken = next
+	}
+}
+
+// classify returns the reason of an api error and whether the call can be
+// retried: rate limits, server errors and network errors are retryable
+func classify(err error) (string, bool) {
+	var apiErr *googleapi.Error
+	if errors.As(err, &apiErr) {
+		reason := strconv.Itoa(apiErr.Code)
+		switch apiErr.Code {
+		case http.StatusTooManyRequests,
+			http.StatusInternalServerError,
+			http.StatusBadGateway,
+			http.StatusServiceUnavailable,
+			http.StatusGatewayTimeout:
+			return reason, true
+		case http.StatusForbidden:
+			// Quota errors are returned as 403 with a rate limit reason
+			for _, e := range apiErr.Errors {
+				if e.Reason == "rateLimitExceeded" || e.Reason == "userRateLimitExceeded" {
+					return reason, true
+				}
+			}
+		}
+		return reason, false
+	}
+	if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return "context", false
+	}
+	var netErr net.Error
+	if errors.As(err, &netErr) {
+		return "network", true
+	}
+	return "unknown", false
+}
+
+// backoff returns a random delay up to the exponential ceiling of the attempt
+func backoff(attempt int) time.Duration {
+	ceiling := float64(apiBackoffBase) * math.Pow(2, float64(attempt-1))
+	if ceiling > float64(apiBackoffMax) {
+		ceiling = float64(apiBackoffMax)
+	}
+	if ceiling < 1 {
+		return 0
+	}
+	return time.Duration(mrand.Int63n(int64(ceiling)))
+}
+
+// retryAfter returns the delay asked for by the Retry-After header of an api
+// error, given in seconds or as a date, capped to apiRetryAfterMax
+func retryAfter(err error) (time.Duration, bool) {
+	var apiErr *googleapi.Error
+	if !errors.As(err, &apiErr) || apiErr.Header == nil {
+		return 0, false
+	}
+	value := apiErr.Header.Get("Retry-After")
+	if value == "" {
+		return 0, false
+	}
+
+	var delay time.Duration
+	if seconds, err := strconv.Atoi(value); err == nil {
+		delay = time.Duration(seconds) * time.Second
+	} else if date, err := http.ParseTime(value); err == nil {
+		delay = time.Until(date)
+	} else {
+		return 0, false
+	}
+	if delay < 0 {
+		delay = 0
+	}
+	if delay > apiRetryAfterMax {
+		delay = apiRetryAfterMax
+	}
+	return delay, true
+}
+
+// newRequestID returns a random UUID to identify a mutating request, so that
+// its retries are not applied twice
+func newRequestID() string {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		log.Error("error generating request id: ", err)
+		return ""
+	}
+	b[6] = (b[6] & 0x0f) | 0x40
+	b[8] = (b[8] & 0x3f) | 0x80
+	return fmt.Sprintf("%x-%x-%x-%x-%x", b[0:4], b[4:6], b[6:8], b[8:10], b[10:])
+}
@@ -0,0 +1,129 @@
+package snapshot
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/pkg/errors"
+	"github.com/stretchr/testify/assert"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/metrics"
+	"google.golang.org/api/googleapi"
+)
+
+func TestClassify(t *testing.T) {
+	for _, tc := range []struct {
+		err       error
+		reason    string
+		retryable bool
+	}{
+		{&googleapi.Error{Code: 429}, "429", true},
+		{errors.Wrap(&googleapi.Error{Code: 503}, "error taking disk snapshot:"), "503", true},
+		{&googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "rateLimitExceeded"}}}, "403", true},
+		{&googleapi.Error{Code: 403, Errors: []googleapi.ErrorItem{{Reason: "forbidden"}}}, "403", false},
+		{&googleapi.Error{Code: 404}, "404", false},
+		{&net.OpError{Op: "dial", Err: errors.New("connection refused")}, "network", true},
+		{context.Canceled, "context", false},
+		{errors.New("test error"), "unknown", false},
+	} {
+		reason, retryable := classify(tc.err)
+		assert.Equal(t, tc.reason, reason, tc.err.Error())
+		assert.Equal(t, tc.retryable, retryable, tc.err.Error())
+	}
+}
+
+func TestRetryAfter(t *testing.T) {
+	header := http.Header{}
+	header.Set("Retry-After", "7")
+	delay, ok := retryAfter(&googleapi.Error{Code: 429, Header: header})
+	assert.True(t, ok)
+	assert.Equal(t, 7*time.Second, delay)
+
+	header.Set("Retry-After", time.Now().Add(time.Hour).UTC().Format(http.TimeFormat))
+	delay, ok = retryAfter(&googleapi.Error{Code: 503, Header: header})
+	assert.True(t, ok)
+	assert.Equal(t, apiRetryAfterMax, delay)
+
+	_, ok = retryAfter(&googleapi.Error{Code: 503})
+	assert.False(t, ok)
+}
+
+func TestCall(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	metrics := metrics.NewMockPrometheusInterface(mockCtrl)
+	gsc := &GCPSnapClient{Metrics: metrics}
+
+	defer func(base time.Duration) { apiBackoffBase = base }(apiBackoffBase)
+	apiBackoffBase = 0
+
+	// Retryable errors are retried until the call succeeds
+	metrics.EXPECT().ObserveAPICall("Test.Call", "503", gomock.Any()).Times(2)
+	metrics.EXPECT().UpdateAPIRetryCount("Test.Call", "503").Times(2)
+	metrics.EXPECT().ObserveAPICall("Test.Call", "ok", gomock.Any()).Times(1)
+	calls := 0
+	err := gsc.call(context.Background(), "Test.Call", func() error {
+		calls++
+		if calls < 3 {
+			return &googleapi.Error{Code: 503}
+		}
+		return nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, 3, calls)
+
+	// Permanent errors are returned right away
+	metrics.EXPECT().ObserveAPICall("Test.Call", "400", gomock.Any()).Times(1)
+	calls = 0
+	err = gsc.call(context.Background(), "Test.Call", func() error {
+		calls++
+		return &googleapi.Error{Code: 400}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, 1, calls)
+
+	// Retries stop after apiAttempts
+	metrics.EXPECT().ObserveAPICall("Test.Call", "429", gomock.Any()).Times(apiAttempts)
+	metrics.EXPECT().UpdateAPIRetryCount("Test.Call", "429").Times(apiAttempts - 1)
+	calls = 0
+	err = gsc.call(context.Background(), "Test.Call", func() error {
+		calls++
+		return &googleapi.Error{Code: 429}
+	})
+	assert.Error(t, err)
+	assert.Equal(t, apiAttempts, calls)
+}
+
+func TestListPages(t *testing.T) {
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	metrics := metrics.NewMockPrometheusInterface(mockCtrl)
+	gsc := &GCPSnapClient{Metrics: metrics}
+
+	defer func(base time.Duration) { apiBackoffBase = base }(apiBackoffBase)
+	apiBackoffBase = 0
+
+	// Every page is counted, and a failed page is retried on its own
+	metrics.EXPECT().ObserveAPICall("Test.List", "ok", gomock.Any()).Times(3)
+	metrics.EXPECT().ObserveAPICall("Test.List", "503", gomock.Any()).Times(1)
+	metrics.EXPECT().UpdateAPIRetryCount("Test.List", "503").Times(1)
+	pages := map[string]string{"": "p2", "p2": "p3", "p3": ""}
+	requested := []string{}
+	failed := false
+	err := gsc.listPages(context.Background(), "Test.List", func(pageToken string) (string, error) {
+		requested = append(requested, pageToken)
+		if pageToken == "p2" && !failed {
+			failed = true
+			return "", &googleapi.Error{Code: 503}
+		}
+		return pages[pageToken], nil
+	})
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"", "p2", "p2", "p3"}, requested)
+}
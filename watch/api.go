@@ -0,0 +1,36 @@
+package watch
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// APIHandler returns a read-only JSON api with the state of the watcher:
+//
+//	/api/v1/targets     the targets with their disks, snapshots and next snapshot
+//	/api/v1/operations  the operations that are being polled
+func (w *Watcher) APIHandler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/api/v1/targets", func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, r, w.Status(time.Now()))
+	})
+	mux.HandleFunc("/api/v1/operations", func(rw http.ResponseWriter, r *http.Request) {
+		writeJSON(rw, r, w.PendingOperations())
+	})
+	return mux
+}
+
+func writeJSON(rw http.ResponseWriter, r *http.Request, v interface{}) {
+	if r.Method != http.MethodGet {
+		rw.Header().Set("Allow", http.MethodGet)
+		http.Error(rw, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	rw.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(rw).Encode(v); err != nil {
+		log.Error("error writing api response: ", err)
+	}
+}
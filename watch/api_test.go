@@ -0,0 +1,66 @@
+package watch
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestAPIHandler(t *testing.T) {
+	watcher := &Watcher{}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	created := now.Add(-2 * time.Hour)
+	hourly := target{name: "label:name=app", owner: "true", intervalSeconds: 3600}
+	watcher.recordStatus(hourly, []DiskPlan{
+		{
+			Disk:   compute.Disk{Name: "disk", Zone: "https://www.googleapis.com/compute/v1/projects/p/zones/europe-west2-a"},
+			Keep:   []compute.Snapshot{{Name: "snap-new", Status: "READY", StorageBytes: 10, CreationTimestamp: created.Format(GCPSnapshotTimestampLayout)}},
+			Delete: []compute.Snapshot{{Name: "snap-old", Status: "READY", CreationTimestamp: created.Add(-time.Hour).Format(GCPSnapshotTimestampLayout)}},
+			Create: true,
+		},
+	}, time.Time{}, now)
+	watcher.ops.add(Operation{Name: "op", Type: "zonal", Action: "create", Disk: "disk", Started: now})
+
+	handler := watcher.APIHandler()
+
+	rec := httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/targets", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	assert.Equal(t, "application/json", rec.Header().Get("Content-Type"))
+	targets := []TargetStatus{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &targets))
+	if assert.Len(t, targets, 1) && assert.Len(t, targets[0].Disks, 1) {
+		assert.Equal(t, "label:name=app", targets[0].Name)
+		assert.Equal(t, float64(3600), targets[0].IntervalSeconds)
+		disk := targets[0].Disks[0]
+		assert.Equal(t, "europe-west2-a", disk.Location)
+		assert.True(t, now.Add(time.Hour).Equal(disk.NextSnapshot))
+		if assert.Len(t, disk.Snapshots, 2) {
+			assert.Equal(t, "snap-new", disk.Snapshots[0].Name)
+			assert.False(t, disk.Snapshots[0].Expiring)
+			assert.True(t, disk.Snapshots[0].AgeSeconds >= 7200)
+			assert.Equal(t, "snap-old", disk.Snapshots[1].Name)
+			assert.True(t, disk.Snapshots[1].Expiring)
+		}
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/api/v1/operations", nil))
+	assert.Equal(t, http.StatusOK, rec.Code)
+	ops := []Operation{}
+	assert.NoError(t, json.Unmarshal(rec.Body.Bytes(), &ops))
+	if assert.Len(t, ops, 1) {
+		assert.Equal(t, "op", ops[0].Name)
+		assert.Equal(t, "create", ops[0].Action)
+	}
+
+	rec = httptest.NewRecorder()
+	handler.ServeHTTP(rec, httptest.NewRequest(http.MethodPost, "/api/v1/targets", nil))
+	assert.Equal(t, http.StatusMethodNotAllowed, rec.Code)
+}
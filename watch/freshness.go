@@ -0,0 +1,149 @@
+package watch
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/metrics"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// diskStats returns the creation time of the newest READY snapshot of a disk,
+// zero if there is none, and the number and total storage bytes of its
+// snapshots
+func diskStats(snaps []compute.Snapshot) (time.Time, int, int64) {
+	var lastReady time.Time
+	var storageBytes int64
+	for _, snap := range snaps {
+		storageBytes += snap.StorageBytes
+		if snap.Status != snapshotStatusReady {
+			continue
+		}
+		snapTime, err := time.Parse(GCPSnapshotTimestampLayout, snap.CreationTimestamp)
+		if err != nil {
+			continue
+		}
+		if snapTime.After(lastReady) {
+			lastReady = snapTime
+		}
+	}
+	return lastReady, len(snaps), storageBytes
+}
+
+// diskFreshness is what the watcher remembers of a disk of a target, to tell
+// whether it is past its RPO
+type diskFreshness struct {
+	name      string
+	location  string
+	firstSeen time.Time
+	lastReady time.Time
+}
+
+// targetFreshness holds the interval of a target and the freshness of its
+// disks, by self link, as disk names are only unique within a zone or region
+type targetFreshness struct {
+	interval time.Duration
+	disks    map[string]diskFreshness
+}
+
+// recordFreshness updates the snapshot gauges of the disks of a target, as
+// found at the start of the cycle, and its interval. The gauges of disks that
+// no longer match the target are removed.
+func (w *Watcher) recordFreshness(t target, plans []DiskPlan, now time.Time) {
+	id := t.id()
+	interval, err := t.interval(now)
+	if err == nil {
+		w.Metrics.UpdateTargetInterval(id, interval)
+	} else {
+		log.Error("target ", t.name, ": ", err)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.recorded == nil {
+		w.recorded = map[metrics.Target]targetFreshness{}
+	}
+	previous := w.recorded[id].disks
+
+	disks := map[string]diskFreshness{}
+	for _, plan := range plans {
+		snaps := append(append([]compute.Snapshot{}, plan.Keep...), plan.Delete...)
+		lastReady, count, storageBytes := diskStats(snaps)
+		location := diskLocation(plan.Disk)
+		w.Metrics.UpdateDiskSnapshotStats(id, plan.Disk.Name, location, lastReady, count, storageBytes)
+
+		firstSeen := now
+		if prev, ok := previous[plan.Disk.SelfLink]; ok {
+			firstSeen = prev.firstSeen
+		}
+		disks[plan.Disk.SelfLink] = diskFreshness{
+			name:      plan.Disk.Name,
+			location:  location,
+			firstSeen: firstSeen,
+			lastReady: lastReady,
+		}
+	}
+
+	for link, df := range previous {
+		if _, ok := disks[link]; !ok {
+			w.Metrics.DeleteDiskSnapshotStats(id, df.name, df.location)
+		}
+	}
+	w.recorded[id] = targetFreshness{interval: interval, disks: disks}
+}
+
+// forgetTargets removes the gauges and the status of the targets that are no
+// longer configured
+func (w *Watcher) forgetTargets(targets []target) {
+	configured := map[metrics.Target]bool{}
+	for _, t := range targets {
+		configured[t.id()] = true
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	for id := range w.status {
+		if !configured[id] {
+			delete(w.status, id)
+		}
+	}
+	for id, tf := range w.recorded {
+		if configured[id] {
+			continue
+		}
+		for _, df := range tf.disks {
+			w.Metrics.DeleteDiskSnapshotStats(id, df.name, df.location)
+		}
+		w.Metrics.DeleteTargetInterval(id)
+		delete(w.recorded, id)
+	}
+}
+
+// DisksPastRPO returns the disks, as config:target/location/disk, whose newest
+// READY snapshot is older than the given number of intervals of their target.
+// Disks without a snapshot count from when they were first seen.
+func (w *Watcher) DisksPastRPO(now time.Time, intervals float64) []string {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	past := []string{}
+	for id, tf := range w.recorded {
+		if tf.interval <= 0 {
+			continue
+		}
+		rpo := time.Duration(float64(tf.interval) * intervals)
+		for _, df := range tf.disks {
+			last := df.lastReady
+			if last.IsZero() {
+				last = df.firstSeen
+			}
+			if now.Sub(last) > rpo {
+				past = append(past, fmt.Sprintf("%s:%s/%s/%s", id.Config, id.Name, df.location, df.name))
+			}
+		}
+	}
+	sort.Strings(past)
+	return past
+}
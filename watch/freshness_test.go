@@ -0,0 +1,139 @@
+package watch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/metrics"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/models"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestDiskStats(t *testing.T) {
+	now := time.Now().UTC().Truncate(time.Second)
+	snaps := []compute.Snapshot{
+		{Name: "old", Status: "READY", StorageBytes: 10, CreationTimestamp: now.Add(-2 * time.Hour).Format(GCPSnapshotTimestampLayout)},
+		{Name: "new", Status: "READY", StorageBytes: 20, CreationTimestamp: now.Add(-time.Hour).Format(GCPSnapshotTimestampLayout)},
+		{Name: "creating", Status: "CREATING", StorageBytes: 0, CreationTimestamp: now.Format(GCPSnapshotTimestampLayout)},
+	}
+
+	lastReady, count, storageBytes := diskStats(snaps)
+	assert.True(t, now.Add(-time.Hour).Equal(lastReady))
+	assert.Equal(t, 3, count)
+	assert.Equal(t, int64(30), storageBytes)
+
+	lastReady, count, storageBytes = diskStats(nil)
+	assert.True(t, lastReady.IsZero())
+	assert.Equal(t, 0, count)
+	assert.Equal(t, int64(0), storageBytes)
+}
+
+func TestRecordFreshness(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	m := metrics.NewMockPrometheusInterface(mockCtrl)
+	watcher := &Watcher{Metrics: m}
+
+	now := time.Date(2020, 7, 1, 12, 30, 0, 0, time.UTC)
+	scheduled := target{name: "scheduled", owner: "true", config: "Labels[0]", schedule: &models.Schedule{Cron: "0 */6 * * *"}}
+	id := metrics.Target{Name: "scheduled", Owner: "true", Config: "Labels[0]"}
+	// Disks with the same name in different zones
+	plans := []DiskPlan{
+		{Disk: compute.Disk{Name: "a", Zone: "zones/z1", SelfLink: "z1/a"}},
+		{Disk: compute.Disk{Name: "a", Zone: "zones/z2", SelfLink: "z2/a"}},
+	}
+
+	m.EXPECT().UpdateTargetInterval(id, 6*time.Hour).Times(2)
+	m.EXPECT().UpdateDiskSnapshotStats(id, "a", "z1", time.Time{}, 0, int64(0)).Times(2)
+	m.EXPECT().UpdateDiskSnapshotStats(id, "a", "z2", time.Time{}, 0, int64(0)).Times(1)
+	watcher.recordFreshness(scheduled, plans, now)
+	assert.Len(t, watcher.recorded[id].disks, 2)
+
+	// Disk a in z2 no longer matches the target
+	m.EXPECT().DeleteDiskSnapshotStats(id, "a", "z2").Times(1)
+	watcher.recordFreshness(scheduled, plans[:1], now)
+
+	// The target is removed from the configuration
+	m.EXPECT().DeleteDiskSnapshotStats(id, "a", "z1").Times(1)
+	m.EXPECT().DeleteTargetInterval(id).Times(1)
+	watcher.forgetTargets(nil)
+	assert.Empty(t, watcher.recorded)
+}
+
+func TestRecordFreshnessOfTargetsWithTheSameName(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	m := metrics.NewMockPrometheusInterface(mockCtrl)
+	m.EXPECT().UpdateTargetInterval(gomock.Any(), gomock.Any()).AnyTimes()
+	m.EXPECT().UpdateDiskSnapshotStats(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	watcher := &Watcher{Metrics: m}
+
+	// Two targets of the same label with different owners
+	now := time.Now().UTC().Truncate(time.Second)
+	prod := target{name: "label:name=app", owner: "prod", config: "Labels[0]", intervalSeconds: 3600}
+	compliance := target{name: "label:name=app", owner: "compliance", config: "Labels[1]", intervalSeconds: 86400}
+	disk := compute.Disk{Name: "a", Zone: "zones/z1", SelfLink: "z1/a"}
+	watcher.recordFreshness(prod, []DiskPlan{{Disk: disk}}, now)
+	watcher.recordFreshness(compliance, []DiskPlan{{Disk: disk}}, now)
+	watcher.recordStatus(prod, []DiskPlan{{Disk: disk}}, time.Time{}, now)
+	watcher.recordStatus(compliance, []DiskPlan{{Disk: disk}}, time.Time{}, now)
+
+	assert.Len(t, watcher.recorded, 2)
+	status := watcher.Status(now)
+	if assert.Len(t, status, 2) {
+		assert.Equal(t, "prod", status[0].Owner)
+		assert.Equal(t, "compliance", status[1].Owner)
+	}
+
+	// Only the hourly target is past its RPO after 3 hours
+	assert.Equal(t, []string{"Labels[0]:label:name=app/z1/a"}, watcher.DisksPastRPO(now.Add(3*time.Hour), 2))
+
+	// Removing one target keeps the other
+	m.EXPECT().DeleteDiskSnapshotStats(compliance.id(), "a", "z1").Times(1)
+	m.EXPECT().DeleteTargetInterval(compliance.id()).Times(1)
+	watcher.forgetTargets([]target{prod})
+	assert.Len(t, watcher.recorded, 1)
+	assert.Len(t, watcher.Status(now), 1)
+}
+
+func TestDisksPastRPO(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	metrics := metrics.NewMockPrometheusInterface(mockCtrl)
+	metrics.EXPECT().UpdateTargetInterval(gomock.Any(), gomock.Any()).AnyTimes()
+	metrics.EXPECT().UpdateDiskSnapshotStats(gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any(), gomock.Any()).AnyTimes()
+	watcher := &Watcher{Metrics: metrics}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	hourly := target{name: "hourly", config: "Labels[0]", intervalSeconds: 3600}
+	snap := func(age time.Duration) []compute.Snapshot {
+		return []compute.Snapshot{{Status: "READY", CreationTimestamp: now.Add(-age).Format(GCPSnapshotTimestampLayout)}}
+	}
+	watcher.recordFreshness(hourly, []DiskPlan{
+		{Disk: compute.Disk{Name: "fresh", Zone: "z1", SelfLink: "z1/fresh"}, Keep: snap(time.Hour)},
+		{Disk: compute.Disk{Name: "stale", Zone: "z1", SelfLink: "z1/stale"}, Keep: snap(3 * time.Hour)},
+		// A fresh disk of the same name in another zone does not hide the
+		// stale one
+		{Disk: compute.Disk{Name: "stale", Zone: "z2", SelfLink: "z2/stale"}, Keep: snap(time.Hour)},
+		{Disk: compute.Disk{Name: "new", Zone: "z1", SelfLink: "z1/new"}},
+	}, now)
+
+	assert.Equal(t, []string{"Labels[0]:hourly/z1/stale"}, watcher.DisksPastRPO(now, 2))
+
+	// Disks without snapshots are past their RPO once they have been matched
+	// for long enough
+	assert.Equal(t, []string{
+		"Labels[0]:hourly/z1/fresh",
+		"Labels[0]:hourly/z1/new",
+		"Labels[0]:hourly/z1/stale",
+		"Labels[0]:hourly/z2/stale",
+	}, watcher.DisksPastRPO(now.Add(3*time.Hour), 2))
+}
@@ -0,0 +1,19 @@
+package watch
+
+import "time"
+
+// LastCycle returns when the last watch cycle finished, zero if none has
+// finished yet, and its number of failures
+func (w *Watcher) LastCycle() (time.Time, int) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.lastCycle, w.lastCycleFailures
+}
+
+// Ready returns whether a watch cycle has listed the snapshots of the
+// targets. Cycles that fail to do so do not make the watcher ready.
+func (w *Watcher) Ready() bool {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.discovered
+}
@@ -0,0 +1,44 @@
+package watch
+
+import (
+	"context"
+	"sync"
+
+	"golang.org/x/time/rate"
+)
+
+// callLimits limits the create and delete calls of all workers, to stay
+// within the operation rate limits of the project
+type callLimits struct {
+	once     sync.Once
+	inFlight chan struct{}
+	limiter  *rate.Limiter
+}
+
+// limitCall calls fn once a call is allowed by the limits of the watcher
+func (w *Watcher) limitCall(ctx context.Context, fn func() error) error {
+	l := &w.limits
+	l.once.Do(func() {
+		if w.MaxInFlightCalls > 0 {
+			l.inFlight = make(chan struct{}, w.MaxInFlightCalls)
+		}
+		if w.CallsPerSecond > 0 {
+			l.limiter = rate.NewLimiter(rate.Limit(w.CallsPerSecond), 1)
+		}
+	})
+
+	if l.inFlight != nil {
+		select {
+		case l.inFlight <- struct{}{}:
+		case <-ctx.Done():
+			return ctx.Err()
+		}
+		defer func() { <-l.inFlight }()
+	}
+	if l.limiter != nil {
+		if err := l.limiter.Wait(ctx); err != nil {
+			return err
+		}
+	}
+	return fn()
+}
@@ -0,0 +1,88 @@
+package watch
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLimitCall(t *testing.T) {
+	watcher := &Watcher{MaxInFlightCalls: 2}
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := watcher.limitCall(context.Background(), func() error {
+				mu.Lock()
+				inFlight++
+				if inFlight > maxInFlight {
+					maxInFlight = inFlight
+				}
+				mu.Unlock()
+				time.Sleep(5 * time.Millisecond)
+				mu.Lock()
+				inFlight--
+				mu.Unlock()
+				return nil
+			})
+			assert.NoError(t, err)
+		}()
+	}
+	wg.Wait()
+	assert.Equal(t, 2, maxInFlight)
+
+	// Calls waiting for a slot give up once ctx is done
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	watcher.limits.inFlight <- struct{}{}
+	watcher.limits.inFlight <- struct{}{}
+	err := watcher.limitCall(ctx, func() error { return nil })
+	assert.Equal(t, context.Canceled, err)
+}
+
+func TestStartOperationWaitsForPollSlotFirst(t *testing.T) {
+	watcher := &Watcher{MaxInFlightCalls: 1, MaxPolledOperations: 1}
+
+	// The only poll slot is taken by an operation that does not finish
+	assert.NoError(t, watcher.ops.reserve(context.Background(), watcher.MaxPolledOperations))
+
+	ctx, cancel := context.WithCancel(context.Background())
+	blocked := make(chan error)
+	go func() {
+		blocked <- watcher.startOperation(ctx, func() error {
+			t.Error("operation started without a free poll slot")
+			return nil
+		})
+	}()
+	time.Sleep(10 * time.Millisecond)
+
+	// The waiting operation does not hold the only in-flight call slot
+	called := make(chan struct{})
+	go func() {
+		assert.NoError(t, watcher.limitCall(context.Background(), func() error {
+			close(called)
+			return nil
+		}))
+	}()
+	select {
+	case <-called:
+	case <-time.After(time.Second):
+		t.Fatal("call blocked by an operation waiting for a poll slot")
+	}
+
+	cancel()
+	assert.Equal(t, context.Canceled, <-blocked)
+
+	// A failed call releases its poll slot
+	watcher.ops.release()
+	err := watcher.startOperation(context.Background(), func() error { return context.DeadlineExceeded })
+	assert.Equal(t, context.DeadlineExceeded, err)
+	assert.NoError(t, watcher.startOperation(context.Background(), func() error { return nil }))
+}
@@ -0,0 +1,147 @@
+package watch
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Operation is a create or delete snapshot operation that is being polled
+type Operation struct {
+	// Link to the operation
+	Name string `json:"name"`
+	// Global, zonal or regional
+	Type string `json:"type"`
+	// Create or delete
+	Action  string    `json:"action"`
+	Disk    string    `json:"disk"`
+	Started time.Time `json:"started"`
+}
+
+// operations keeps track of the operations that are being polled, so that
+// they can be waited for, or abandoned
+type operations struct {
+	mu      sync.Mutex
+	wg      sync.WaitGroup
+	nextID  int
+	pending map[int]Operation
+	failed  int
+	ctx     context.Context
+	cancel  context.CancelFunc
+	slots   chan struct{}
+}
+
+// reserve blocks until a slot is free to poll an operation, when at most
+// limit operations may be polled at once. A slot is released when its
+// operation is done, or by release if no operation was started.
+func (o *operations) reserve(ctx context.Context, limit int) error {
+	if limit <= 0 {
+		return nil
+	}
+	o.mu.Lock()
+	if o.slots == nil {
+		o.slots = make(chan struct{}, limit)
+	}
+	slots := o.slots
+	o.mu.Unlock()
+
+	select {
+	case slots <- struct{}{}:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// release frees a reserved slot
+func (o *operations) release() {
+	o.mu.Lock()
+	slots := o.slots
+	o.mu.Unlock()
+	if slots != nil {
+		<-slots
+	}
+}
+
+// context returns the context to poll operations with. It is cancelled when
+// the pending operations are abandoned.
+func (o *operations) context() context.Context {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.ctx == nil {
+		o.ctx, o.cancel = context.WithCancel(context.Background())
+	}
+	return o.ctx
+}
+
+// abandon cancels the polling of all operations and returns the ones that
+// were still pending
+func (o *operations) abandon() []Operation {
+	o.context()
+	o.cancel()
+	return o.list()
+}
+
+// list returns the pending operations, oldest first
+func (o *operations) list() []Operation {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	ops := []Operation{}
+	for _, op := range o.pending {
+		ops = append(ops, op)
+	}
+	sort.Slice(ops, func(i, j int) bool {
+		return ops[i].Started.Before(ops[j].Started)
+	})
+	return ops
+}
+
+// get returns the pending operation with the given id
+func (o *operations) get(id int) (Operation, bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	op, ok := o.pending[id]
+	return op, ok
+}
+
+// add starts tracking an operation and returns its id
+func (o *operations) add(op Operation) int {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if o.pending == nil {
+		o.pending = map[int]Operation{}
+	}
+	o.nextID++
+	o.pending[o.nextID] = op
+	o.wg.Add(1)
+	return o.nextID
+}
+
+// done stops tracking the operation with the given id
+func (o *operations) done(id int, success bool) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	if _, ok := o.pending[id]; !ok {
+		return
+	}
+	delete(o.pending, id)
+	if !success {
+		o.failed++
+	}
+	if o.slots != nil {
+		<-o.slots
+	}
+	o.wg.Done()
+}
+
+// wait blocks until there are no pending operations and returns the number
+// of operations that failed since the last call
+func (o *operations) wait() int {
+	o.wg.Wait()
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	failed := o.failed
+	o.failed = 0
+	return failed
+}
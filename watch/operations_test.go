@@ -0,0 +1,30 @@
+package watch
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOperationsReserve(t *testing.T) {
+	ops := &operations{}
+
+	// No limit
+	assert.NoError(t, ops.reserve(context.Background(), 0))
+
+	// Only one operation at once
+	assert.NoError(t, ops.reserve(context.Background(), 1))
+	id := ops.add(Operation{Name: "op"})
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	assert.Equal(t, context.DeadlineExceeded, ops.reserve(ctx, 1))
+
+	// A slot is free again once the operation is done
+	ops.done(id, true)
+	assert.NoError(t, ops.reserve(context.Background(), 1))
+	ops.release()
+	assert.Equal(t, 0, ops.wait())
+}
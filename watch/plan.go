@@ -0,0 +1,147 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/snapshot"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// DiskPlan holds what a watch cycle does for a disk of a target: the snapshots
+// that are kept, the ones that expired and will be deleted, and whether a new
+// snapshot will be created
+type DiskPlan struct {
+	Target string
+	Owner  string
+	Disk   compute.Disk
+	Keep   []compute.Snapshot
+	Delete []compute.Snapshot
+	Create bool
+}
+
+// planDisks evaluates the retention of the snapshots of the given owner for
+// every disk, and whether a new snapshot is needed
+func planDisks(disks []compute.Disk, snaps snapshot.SnapshotIndex, owner string, retention Retention, lastAcceptedCreation time.Time) []DiskPlan {
+	plans := []DiskPlan{}
+	for _, disk := range disks {
+		// Snapshots per disk created by the snapshotter for the owner
+		diskSnaps := snaps.Owned(disk.SelfLink, owner)
+
+		// Snapshots that are not kept by the retention need to be deleted
+		snapsToDelete := retention.Expired(diskSnaps)
+		expired := map[string]bool{}
+		for _, s := range snapsToDelete {
+			expired[s.Name] = true
+		}
+
+		// Check timestamps of all snapshots to see if a new one is needed
+		snapNeeded := true
+		snapsToKeep := []compute.Snapshot{}
+		for _, snap := range diskSnaps {
+			if !expired[snap.Name] {
+				snapsToKeep = append(snapsToKeep, *snap)
+			}
+
+			snapTime, err := time.Parse(GCPSnapshotTimestampLayout, snap.CreationTimestamp)
+			if err != nil {
+				continue
+			}
+
+			// If a snap was taken after last accepted creation time we do not need a new one
+			if snapTime.After(lastAcceptedCreation) {
+				snapNeeded = false
+			}
+		}
+
+		plans = append(plans, DiskPlan{
+			Owner:  owner,
+			Disk:   disk,
+			Keep:   snapsToKeep,
+			Delete: snapsToDelete,
+			Create: snapNeeded,
+		})
+	}
+	return plans
+}
+
+// planTarget returns the plans for the disks of the target at the given time,
+// and the next time a snapshot of the target will be due
+func (w *Watcher) planTarget(ctx context.Context, t target, snaps snapshot.SnapshotIndex, now time.Time) ([]DiskPlan, time.Time, error) {
+	retention := NewRetention(now, t.retentionPeriodHours, t.retention)
+	retention.MinKeep = t.minKeep
+	if w.MinKeep > retention.MinKeep {
+		retention.MinKeep = w.MinKeep
+	}
+	lastAcceptedCreation, nextDue, err := t.lastAcceptedCreation(now)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	// Get disks
+	disks, err := t.getDisks(ctx)
+	if err != nil {
+		return nil, time.Time{}, err
+	}
+
+	plans := planDisks(disks, snaps, t.owner, retention, lastAcceptedCreation)
+	for i := range plans {
+		plans[i].Target = t.name
+	}
+	return plans, nextDue, nil
+}
+
+// Plan runs discovery and retention evaluation for all configured targets,
+// without creating or deleting anything, and returns the plans of all disks
+func (w *Watcher) Plan(ctx context.Context) ([]DiskPlan, error) {
+	targets := targetsFromConfig(w.config(), w.GSC, w.owner())
+	if len(targets) == 0 {
+		return nil, nil
+	}
+
+	snaps, err := w.GSC.ListClientCreatedSnapshots(ctx, owners(targets))
+	if err != nil {
+		return nil, err
+	}
+
+	plans := []DiskPlan{}
+	now := time.Now()
+	for _, t := range targets {
+		tPlans, _, err := w.planTarget(ctx, t, snaps, now)
+		if err != nil {
+			return nil, fmt.Errorf("target %s: %v", t.name, err)
+		}
+		plans = append(plans, tPlans...)
+	}
+	return plans, nil
+}
+
+// WritePlan writes the given plans as a table, with a row per disk
+func WritePlan(out io.Writer, plans []DiskPlan) error {
+	tw := tabwriter.NewWriter(out, 0, 8, 2, ' ', 0)
+	fmt.Fprintln(tw, "TARGET\tDISK\tKEPT\tEXPIRING\tTO CREATE")
+	for _, p := range plans {
+		expiring := []string{}
+		for _, s := range p.Delete {
+			expiring = append(expiring, s.Name)
+		}
+		create := "no"
+		if p.Create {
+			create = "yes"
+		}
+		fmt.Fprintf(tw, "%s\t%s\t%d\t%d %s\t%s\n",
+			p.Target, p.Disk.Name, len(p.Keep), len(p.Delete), formatNames(expiring), create)
+	}
+	return tw.Flush()
+}
+
+func formatNames(names []string) string {
+	if len(names) == 0 {
+		return ""
+	}
+	return fmt.Sprintf("(%s)", strings.Join(names, ", "))
+}
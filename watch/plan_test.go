@@ -0,0 +1,72 @@
+package watch
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/golang/mock/gomock"
+	"github.com/stretchr/testify/assert"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/metrics"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/snapshot"
+	compute "google.golang.org/api/compute/v1"
+)
+
+func TestPlanDisks(t *testing.T) {
+	now := time.Now()
+	owned := map[string]string{snapshot.SnapshotterLabel: snapshot.SnapshotterLabelValue}
+	disks := []compute.Disk{
+		{Name: "disk", Zone: "test", SelfLink: "link/disk"},
+	}
+	snaps := snapshot.SnapshotIndex{
+		"link/disk": {
+			{Name: "snap-new", Labels: owned, CreationTimestamp: now.Add(-90 * time.Minute).Format(GCPSnapshotTimestampLayout)},
+			{Name: "snap-old", Labels: owned, CreationTimestamp: now.Add(-3 * time.Hour).Format(GCPSnapshotTimestampLayout)},
+		},
+	}
+
+	plans := planDisks(disks, snaps, snapshot.SnapshotterLabelValue, NewRetention(now, 2, nil), now.Add(-time.Hour))
+	assert.Len(t, plans, 1)
+	assert.Equal(t, []string{"snap-new"}, snapshotNames(plans[0].Keep))
+	assert.Equal(t, []string{"snap-old"}, snapshotNames(plans[0].Delete))
+	assert.True(t, plans[0].Create)
+
+	plans[0].Target = "label:name=app"
+	out := &bytes.Buffer{}
+	if err := WritePlan(out, plans); err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, `TARGET          DISK  KEPT  EXPIRING      TO CREATE
+label:name=app  disk  1     1 (snap-old)  yes
+`, out.String())
+}
+
+func TestCheckAndSnapDisksDryRun(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// No calls are expected on the mocks in dry run mode
+	mgsc := snapshot.NewMockGCPSnapClientInterface(mockCtrl)
+	metrics := metrics.NewMockPrometheusInterface(mockCtrl)
+
+	watcher := &Watcher{
+		GSC:     mgsc,
+		Metrics: metrics,
+		DryRun:  true,
+	}
+
+	now := time.Now()
+	owned := map[string]string{snapshot.SnapshotterLabel: snapshot.SnapshotterLabelValue}
+	disks := []compute.Disk{
+		{Name: "disk", Zone: "test", SelfLink: "link/disk"},
+	}
+	snaps := snapshot.SnapshotIndex{
+		"link/disk": {
+			{Name: "snap-old", Labels: owned, CreationTimestamp: now.Add(-3 * time.Hour).Format(GCPSnapshotTimestampLayout)},
+		},
+	}
+
+	watcher.CheckAndSnapDisks(context.Background(), disks, snaps, snapshot.SnapshotterLabelValue, NewRetention(now, 2, nil), now.Add(-time.Hour))
+}
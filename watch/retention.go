@@ -0,0 +1,139 @@
+package watch
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/models"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// Retention decides which of the snapshots of a disk should be kept.
+// Snapshots created after Start are always kept. When a Policy is set,
+// READY snapshots picked by any of its tiers are kept as well, and so are
+// snapshots that are still being created, so that they can become READY. Regardless of age,
+// the newest MinKeep READY snapshots are never expired.
+type Retention struct {
+	Start   time.Time
+	Policy  *models.RetentionPolicy
+	MinKeep int
+}
+
+// NewRetention returns the retention for a target, given its flat retention
+// period and optional GFS policy. Without a policy, everything older than the
+// retention period expires, as before. With a policy, a zero retention period
+// means the tiers alone decide what is kept.
+func NewRetention(now time.Time, retentionPeriodHours int64, policy *models.RetentionPolicy) Retention {
+	start := now.Add(-time.Duration(retentionPeriodHours) * time.Hour)
+	if policy != nil && retentionPeriodHours <= 0 {
+		start = now
+	}
+	return Retention{Start: start, Policy: policy}
+}
+
+const snapshotStatusReady string = "READY"
+
+// Statuses of snapshots that are still in progress
+var snapshotStatusPending = map[string]bool{
+	"CREATING":  true,
+	"UPLOADING": true,
+}
+
+type bucketFunc func(t time.Time) string
+
+// Buckets used by the retention tiers. Times are bucketed in UTC.
+var (
+	hourlyBucket  bucketFunc = func(t time.Time) string { return t.UTC().Format("2006-01-02T15") }
+	dailyBucket   bucketFunc = func(t time.Time) string { return t.UTC().Format("2006-01-02") }
+	monthlyBucket bucketFunc = func(t time.Time) string { return t.UTC().Format("2006-01") }
+	yearlyBucket  bucketFunc = func(t time.Time) string { return t.UTC().Format("2006") }
+	weeklyBucket  bucketFunc = func(t time.Time) string {
+		year, week := t.UTC().ISOWeek()
+		return fmt.Sprintf("%d-W%02d", year, week)
+	}
+)
+
+type timedSnapshot struct {
+	snap *compute.Snapshot
+	time time.Time
+}
+
+// Expired returns the snapshots that are not kept by the retention
+func (r Retention) Expired(snaps []*compute.Snapshot) []compute.Snapshot {
+	timed := []timedSnapshot{}
+	for _, snap := range snaps {
+		snapTime, err := time.Parse(GCPSnapshotTimestampLayout, snap.CreationTimestamp)
+		if err != nil {
+			log.Error("failed to parse timestamp:", err)
+			continue
+		}
+		timed = append(timed, timedSnapshot{snap: snap, time: snapTime})
+	}
+
+	// Newest first, so that every bucket is represented by its newest snapshot
+	sort.SliceStable(timed, func(i, j int) bool {
+		return timed[i].time.After(timed[j].time)
+	})
+
+	keep := map[string]bool{}
+	if r.Policy != nil {
+		for _, tier := range []struct {
+			count  int
+			bucket bucketFunc
+		}{
+			{r.Policy.Hourly, hourlyBucket},
+			{r.Policy.Daily, dailyBucket},
+			{r.Policy.Weekly, weeklyBucket},
+			{r.Policy.Monthly, monthlyBucket},
+			{r.Policy.Yearly, yearlyBucket},
+		} {
+			seen := map[string]bool{}
+			for _, ts := range timed {
+				if len(seen) >= tier.count {
+					break
+				}
+				// Only READY snapshots represent a bucket, so that a failed
+				// or unfinished snapshot never takes the place of a backup
+				if ts.snap.Status != snapshotStatusReady {
+					continue
+				}
+				b := tier.bucket(ts.time)
+				if seen[b] {
+					continue
+				}
+				seen[b] = true
+				keep[ts.snap.Name] = true
+			}
+		}
+		for _, ts := range timed {
+			if snapshotStatusPending[ts.snap.Status] {
+				keep[ts.snap.Name] = true
+			}
+		}
+	}
+
+	// Keep the newest READY snapshots to never drop below MinKeep, even if
+	// creating new snapshots has been failing for a while
+	ready := 0
+	for _, ts := range timed {
+		if ts.snap.Status != snapshotStatusReady {
+			continue
+		}
+		if ready < r.MinKeep && !keep[ts.snap.Name] && ts.time.Before(r.Start) {
+			log.Warn("Keeping expired snapshot ", ts.snap.Name, " to retain at least ", r.MinKeep, " ready snapshot(s)")
+			keep[ts.snap.Name] = true
+		}
+		ready++
+	}
+
+	expired := []compute.Snapshot{}
+	for _, ts := range timed {
+		if !ts.time.Before(r.Start) || keep[ts.snap.Name] {
+			continue
+		}
+		expired = append(expired, *ts.snap)
+	}
+	return expired
+}
@@ -0,0 +1,126 @@
+package watch
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/models"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// hourlySnapshots returns a READY snapshot per hour, newest first, starting at
+// now
+func hourlySnapshots(now time.Time, count int) []*compute.Snapshot {
+	snaps := []*compute.Snapshot{}
+	for i := 0; i < count; i++ {
+		t := now.Add(-time.Duration(i) * time.Hour)
+		snaps = append(snaps, &compute.Snapshot{
+			Name:              fmt.Sprintf("snap-%03d", i),
+			Status:            "READY",
+			CreationTimestamp: t.Format(GCPSnapshotTimestampLayout),
+		})
+	}
+	return snaps
+}
+
+func snapshotNames(snaps []compute.Snapshot) []string {
+	names := []string{}
+	for _, s := range snaps {
+		names = append(names, s.Name)
+	}
+	return names
+}
+
+func TestRetentionFlatCutoff(t *testing.T) {
+	now := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	snaps := hourlySnapshots(now, 5)
+
+	retention := NewRetention(now, 2, nil)
+	expired := retention.Expired(snaps)
+
+	assert.Equal(t, []string{"snap-003", "snap-004"}, snapshotNames(expired))
+}
+
+func TestRetentionTiers(t *testing.T) {
+	now := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	// Four days worth of hourly snapshots
+	snaps := hourlySnapshots(now, 96)
+
+	retention := NewRetention(now, 0, &models.RetentionPolicy{
+		Hourly: 3,
+		Daily:  3,
+	})
+	expired := snapshotNames(retention.Expired(snaps))
+
+	// 3 hourly snapshots, plus the newest of the 2 previous days, as the
+	// newest of today is already kept by the hourly tier
+	assert.Len(t, expired, 96-5)
+	kept := map[string]bool{}
+	for _, s := range snaps {
+		kept[s.Name] = true
+	}
+	for _, name := range expired {
+		delete(kept, name)
+	}
+	assert.Equal(t, map[string]bool{
+		"snap-000": true,
+		"snap-001": true,
+		"snap-002": true,
+		// 2020-06-30T23:00 and 2020-06-29T23:00
+		"snap-013": true,
+		"snap-037": true,
+	}, kept)
+}
+
+func TestRetentionTiersWithPeriod(t *testing.T) {
+	now := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	snaps := hourlySnapshots(now, 48)
+
+	// Everything from the last 6 hours plus a daily snapshot for 2 days
+	retention := NewRetention(now, 6, &models.RetentionPolicy{Daily: 2})
+	expired := snapshotNames(retention.Expired(snaps))
+
+	assert.Len(t, expired, 48-8)
+	assert.NotContains(t, expired, "snap-006")
+	assert.NotContains(t, expired, "snap-013")
+}
+
+func TestRetentionMinKeep(t *testing.T) {
+	now := time.Date(2020, 7, 1, 12, 0, 0, 0, time.UTC)
+	// Snapshot creation has been failing for a day
+	snaps := hourlySnapshots(now.Add(-24*time.Hour), 5)
+	snaps[0].Status = "FAILED"
+
+	retention := NewRetention(now, 2, nil)
+	retention.MinKeep = 2
+	expired := snapshotNames(retention.Expired(snaps))
+
+	// The failed snapshot does not count towards the ready ones
+	assert.Equal(t, []string{"snap-000", "snap-003", "snap-004"}, expired)
+}
+
+func TestRetentionTiersIgnoreUnreadySnapshots(t *testing.T) {
+	now := time.Date(2020, 7, 3, 12, 0, 0, 0, time.UTC)
+	day1 := time.Date(2020, 7, 1, 10, 0, 0, 0, time.UTC)
+	day2 := time.Date(2020, 7, 2, 10, 0, 0, 0, time.UTC)
+	snap := func(name, status string, t time.Time) *compute.Snapshot {
+		return &compute.Snapshot{Name: name, Status: status, CreationTimestamp: t.Format(GCPSnapshotTimestampLayout)}
+	}
+	snaps := []*compute.Snapshot{
+		snap("d1-ready", "READY", day1),
+		snap("d1-failed", "FAILED", day1.Add(time.Hour)),
+		snap("d2-ready", "READY", day2),
+		snap("d2-failed", "FAILED", day2.Add(time.Hour)),
+		snap("d2-creating", "CREATING", day2.Add(2*time.Hour)),
+	}
+
+	retention := NewRetention(now, 0, &models.RetentionPolicy{Daily: 7})
+	retention.MinKeep = 1
+	expired := snapshotNames(retention.Expired(snaps))
+
+	// The newer FAILED and CREATING snapshots do not take the buckets of the
+	// READY ones, and the CREATING one is kept until it is done
+	assert.ElementsMatch(t, []string{"d1-failed", "d2-failed"}, expired)
+}
@@ -0,0 +1,31 @@
+package watch
+
+import (
+	"time"
+
+	"github.com/robfig/cron/v3"
+)
+
+// Limit for looking back for the previous activation of a schedule
+const maxScheduleLookback = 5 * 366 * 24 * time.Hour
+
+// previousActivation returns the latest activation time of the schedule that
+// is not after now. The cron library only looks forward, so we look back for
+// an increasingly long window until it contains an activation and then walk
+// forward to the latest one.
+func previousActivation(sched cron.Schedule, now time.Time) (time.Time, bool) {
+	for lookback := time.Minute; lookback <= maxScheduleLookback; lookback *= 2 {
+		t := sched.Next(now.Add(-lookback))
+		if t.IsZero() || t.After(now) {
+			continue
+		}
+		for {
+			next := sched.Next(t)
+			if next.IsZero() || next.After(now) {
+				return t, true
+			}
+			t = next
+		}
+	}
+	return time.Time{}, false
+}
@@ -0,0 +1,56 @@
+package watch
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/models"
+)
+
+func TestPreviousActivation(t *testing.T) {
+	sched, err := (&models.Schedule{Cron: "30 2 * * *", Timezone: "Europe/London"}).Parse()
+	if err != nil {
+		t.Fatal(err)
+	}
+	london, err := time.LoadLocation("Europe/London")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Later the same day
+	now := time.Date(2020, 7, 1, 15, 0, 0, 0, london)
+	prev, ok := previousActivation(sched, now)
+	assert.True(t, ok)
+	assert.True(t, prev.Equal(time.Date(2020, 7, 1, 2, 30, 0, 0, london)))
+
+	// Before the activation of the day
+	now = time.Date(2020, 7, 1, 2, 29, 0, 0, london)
+	prev, ok = previousActivation(sched, now)
+	assert.True(t, ok)
+	assert.True(t, prev.Equal(time.Date(2020, 6, 30, 2, 30, 0, 0, london)))
+
+	// Exactly on the activation
+	now = time.Date(2020, 7, 1, 2, 30, 0, 0, london)
+	prev, ok = previousActivation(sched, now)
+	assert.True(t, ok)
+	assert.True(t, prev.Equal(now))
+}
+
+func TestLastAcceptedCreationRejectsEvery(t *testing.T) {
+	now := time.Date(2020, 7, 1, 15, 0, 0, 0, time.UTC)
+
+	// Calendar descriptors are fine
+	daily := target{name: "daily", schedule: &models.Schedule{Cron: "@daily"}}
+	prev, next, err := daily.lastAcceptedCreation(now)
+	assert.NoError(t, err)
+	assert.True(t, prev.Equal(time.Date(2020, 7, 1, 0, 0, 0, 0, time.UTC)))
+	assert.True(t, next.Equal(time.Date(2020, 7, 2, 0, 0, 0, 0, time.UTC)))
+
+	// Fixed intervals have no previous activation
+	every := target{name: "every", schedule: &models.Schedule{Cron: "@every 1h"}}
+	_, _, err = every.lastAcceptedCreation(now)
+	assert.Error(t, err)
+	_, err = every.interval(now)
+	assert.Error(t, err)
+}
@@ -0,0 +1,150 @@
+package watch
+
+import (
+	"sort"
+	"time"
+
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/metrics"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// TargetStatus is the state of a target as of its last check
+type TargetStatus struct {
+	Name  string `json:"name"`
+	Owner string `json:"owner"`
+	// Position of the target in the configuration, like Labels[0]
+	Config string `json:"config"`
+	// Time between snapshots, the current period for scheduled targets
+	IntervalSeconds float64      `json:"intervalSeconds"`
+	Schedule        string       `json:"schedule,omitempty"`
+	CheckedAt       time.Time    `json:"checkedAt"`
+	Disks           []DiskStatus `json:"disks"`
+}
+
+// DiskStatus is the state of a disk matched by a target
+type DiskStatus struct {
+	Name string `json:"name"`
+	// Zone of a zonal disk, or region of a regional disk
+	Location string `json:"location"`
+	// When the next snapshot of the disk is due
+	NextSnapshot time.Time        `json:"nextSnapshot"`
+	Snapshots    []SnapshotStatus `json:"snapshots"`
+}
+
+// SnapshotStatus is a snapshot of a disk owned by the target
+type SnapshotStatus struct {
+	Name         string    `json:"name"`
+	Status       string    `json:"status"`
+	CreatedAt    time.Time `json:"createdAt"`
+	AgeSeconds   int64     `json:"ageSeconds"`
+	StorageBytes int64     `json:"storageBytes"`
+	// Whether the snapshot was not kept by the retention
+	Expiring bool `json:"expiring"`
+}
+
+// recordStatus keeps the state of a target as found by its last check, with
+// the plans of its disks and the next time a scheduled snapshot is due
+func (w *Watcher) recordStatus(t target, plans []DiskPlan, nextDue, now time.Time) {
+	interval, _ := t.interval(now)
+	status := TargetStatus{
+		Name:            t.name,
+		Owner:           t.owner,
+		Config:          t.config,
+		IntervalSeconds: interval.Seconds(),
+		CheckedAt:       now,
+		Disks:           []DiskStatus{},
+	}
+	if t.schedule != nil {
+		status.Schedule = t.schedule.Cron
+	}
+
+	for _, plan := range plans {
+		disk := DiskStatus{
+			Name:      plan.Disk.Name,
+			Location:  diskLocation(plan.Disk),
+			Snapshots: []SnapshotStatus{},
+		}
+
+		var newest time.Time
+		for _, s := range plan.Keep {
+			disk.Snapshots = append(disk.Snapshots, snapshotStatus(s, false))
+		}
+		for _, s := range plan.Delete {
+			disk.Snapshots = append(disk.Snapshots, snapshotStatus(s, true))
+		}
+		for _, s := range disk.Snapshots {
+			if s.CreatedAt.After(newest) {
+				newest = s.CreatedAt
+			}
+		}
+		sort.Slice(disk.Snapshots, func(i, j int) bool {
+			return disk.Snapshots[i].CreatedAt.After(disk.Snapshots[j].CreatedAt)
+		})
+
+		switch {
+		case t.schedule != nil:
+			disk.NextSnapshot = nextDue
+		case plan.Create && w.DryRun:
+			disk.NextSnapshot = now
+		case plan.Create:
+			disk.NextSnapshot = now.Add(interval)
+		default:
+			disk.NextSnapshot = newest.Add(interval)
+		}
+		status.Disks = append(status.Disks, disk)
+	}
+
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	if w.status == nil {
+		w.status = map[metrics.Target]TargetStatus{}
+	}
+	w.status[t.id()] = status
+}
+
+func snapshotStatus(s compute.Snapshot, expiring bool) SnapshotStatus {
+	created, _ := time.Parse(GCPSnapshotTimestampLayout, s.CreationTimestamp)
+	return SnapshotStatus{
+		Name:         s.Name,
+		Status:       s.Status,
+		CreatedAt:    created,
+		StorageBytes: s.StorageBytes,
+		Expiring:     expiring,
+	}
+}
+
+// Status returns the state of all targets as of their last check, by name,
+// with the ages of the snapshots at the given time
+func (w *Watcher) Status(now time.Time) []TargetStatus {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+
+	res := []TargetStatus{}
+	for _, ts := range w.status {
+		disks := []DiskStatus{}
+		for _, d := range ts.Disks {
+			snaps := []SnapshotStatus{}
+			for _, s := range d.Snapshots {
+				s.AgeSeconds = int64(now.Sub(s.CreatedAt).Seconds())
+				snaps = append(snaps, s)
+			}
+			d.Snapshots = snaps
+			disks = append(disks, d)
+		}
+		ts.Disks = disks
+		res = append(res, ts)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Name != res[j].Name {
+			return res[i].Name < res[j].Name
+		}
+		return res[i].Config < res[j].Config
+	})
+	return res
+}
+
+// PendingOperations returns the operations that are being polled, oldest
+// first
+func (w *Watcher) PendingOperations() []Operation {
+	return w.ops.list()
+}
@@ -0,0 +1,137 @@
+package watch
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/pkg/errors"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/metrics"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/models"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/snapshot"
+	compute "google.golang.org/api/compute/v1"
+)
+
+// target holds the settings of a label or description snapshot config that
+// the watcher needs to check its disks
+type target struct {
+	name string
+	// Position of the target in the configuration, like Labels[0]. Unlike
+	// the name, it is unique.
+	config               string
+	intervalSeconds      int64
+	retentionPeriodHours int64
+	retention            *models.RetentionPolicy
+	minKeep              int
+	owner                string
+	schedule             *models.Schedule
+	getDisks             func(ctx context.Context) ([]compute.Disk, error)
+}
+
+// targetsFromConfig returns the targets of the given configuration. Targets
+// that do not set an owner get the default one.
+func targetsFromConfig(sc *models.SnapshotConfigs, gsc snapshot.GCPSnapClientInterface, defaultOwner string) []target {
+	targets := []target{}
+	for i, lConfig := range sc.Labels {
+		t := target{
+			config:               fmt.Sprintf("Labels[%d]", i),
+			intervalSeconds:      lConfig.IntervalSeconds,
+			retentionPeriodHours: lConfig.RetentionPeriodHours,
+			retention:            lConfig.Retention,
+			minKeep:              lConfig.MinKeep,
+			owner:                ownerOrDefault(lConfig.Owner, defaultOwner),
+			schedule:             lConfig.Schedule,
+		}
+		if selector := lConfig.Selector; selector != nil {
+			t.name = fmt.Sprintf("selector:%s", selector)
+			t.getDisks = func(ctx context.Context) ([]compute.Disk, error) { return gsc.GetDisksFromSelector(ctx, selector) }
+		} else {
+			label := lConfig.Label
+			t.name = fmt.Sprintf("label:%s=%s", label.Key, label.Value)
+			t.getDisks = func(ctx context.Context) ([]compute.Disk, error) { return gsc.GetDisksFromLabel(ctx, label) }
+		}
+		targets = append(targets, t)
+	}
+	for i, dConfig := range sc.Descriptions {
+		desc := dConfig.Description
+		targets = append(targets, target{
+			name:                 fmt.Sprintf("description:%s=%s", desc.Key, desc.Value),
+			config:               fmt.Sprintf("Descriptions[%d]", i),
+			intervalSeconds:      dConfig.IntervalSeconds,
+			retentionPeriodHours: dConfig.RetentionPeriodHours,
+			retention:            dConfig.Retention,
+			minKeep:              dConfig.MinKeep,
+			owner:                ownerOrDefault(dConfig.Owner, defaultOwner),
+			schedule:             dConfig.Schedule,
+			getDisks:             func(ctx context.Context) ([]compute.Disk, error) { return gsc.GetDisksFromDescription(ctx, desc) },
+		})
+	}
+	return targets
+}
+
+// id returns what identifies the target in the metrics and the state of the
+// watcher
+func (t target) id() metrics.Target {
+	return metrics.Target{Name: t.name, Owner: t.owner, Config: t.config}
+}
+
+// diskLocation returns the zone of a zonal disk, or the region of a regional
+// disk
+func diskLocation(d compute.Disk) string {
+	if d.Region != "" {
+		return snapshot.FormatLinkString(d.Region)
+	}
+	return snapshot.FormatLinkString(d.Zone)
+}
+
+func ownerOrDefault(owner, defaultOwner string) string {
+	if owner != "" {
+		return owner
+	}
+	return defaultOwner
+}
+
+// owners returns the distinct owners of the given targets
+func owners(targets []target) []string {
+	res := []string{}
+	seen := map[string]bool{}
+	for _, t := range targets {
+		if !seen[t.owner] {
+			seen[t.owner] = true
+			res = append(res, t.owner)
+		}
+	}
+	return res
+}
+
+// lastAcceptedCreation returns the time after which an existing snapshot
+// makes a new one unnecessary, and the next time a snapshot will be due.
+func (t target) lastAcceptedCreation(now time.Time) (time.Time, time.Time, error) {
+	if t.schedule == nil {
+		interval := time.Duration(t.intervalSeconds) * time.Second
+		return now.Add(-interval), now.Add(interval), nil
+	}
+
+	sched, err := t.schedule.Parse()
+	if err != nil {
+		return time.Time{}, time.Time{}, errors.Wrap(err, "error parsing schedule")
+	}
+	prev, ok := previousActivation(sched, now)
+	if !ok {
+		return time.Time{}, time.Time{}, fmt.Errorf("schedule %q has no activation in the past", t.schedule.Cron)
+	}
+	return prev, sched.Next(now), nil
+}
+
+// interval returns the configured time between snapshots of the target. For
+// schedules, it is the time between the previous and the next activation.
+func (t target) interval(now time.Time) (time.Duration, error) {
+	if t.schedule == nil {
+		return time.Duration(t.intervalSeconds) * time.Second, nil
+	}
+	prev, next, err := t.lastAcceptedCreation(now)
+	if err != nil {
+		return 0, err
+	}
+	return next.Sub(prev), nil
+}
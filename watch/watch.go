@@ -1,9 +1,12 @@
 package watch
 
 import (
+	"context"
 	"fmt"
+	"sync"
 	"time"
 
+	"github.com/pkg/errors"
 	log "github.com/sirupsen/logrus"
 	"github.com/utilitywarehouse/gcp-disk-snapshotter/metrics"
 	"github.com/utilitywarehouse/gcp-disk-snapshotter/models"
@@ -14,165 +17,474 @@ import (
 // From https://golang.org/src/time/format.go
 const GCPSnapshotTimestampLayout string = "2006-01-02T15:04:05Z07:00"
 
+// Delay between waits for an operation, which doubles after every wait up to
+// the maximum. Waits block until the operation is done or for about 2
+// minutes, so the delay mostly matters when the api returns early.
+var (
+	pollInterval    = 1 * time.Second
+	pollIntervalMax = 30 * time.Second
+)
+
 type Watcher struct {
 	GSC           snapshot.GCPSnapClientInterface
 	WatchInterval int
 	Metrics       metrics.PrometheusInterface
+	// Minimum number of READY snapshots to keep per disk for every target,
+	// regardless of their age
+	MinKeep int
+	// Value of the snapshotter label that identifies the snapshots owned by
+	// targets that do not set their own owner
+	Owner string
+	// Only log the snapshots that would be created and deleted
+	DryRun bool
+	// Time to wait for pending operations to finish on shutdown
+	ShutdownGracePeriod time.Duration
+	// Time after which an operation stops being polled, unlimited if 0
+	OperationTimeout time.Duration
+	// Maximum number of operations polled at once, unlimited if 0
+	MaxPolledOperations int
+	// Number of disks checked concurrently, 1 if not set
+	Workers int
+	// Maximum number of create and delete calls in flight at once, and per
+	// second across all workers, unlimited if 0
+	MaxInFlightCalls int
+	CallsPerSecond   float64
+
+	mu              sync.Mutex
+	snapshotConfigs *models.SnapshotConfigs
+	ops             operations
+	limits          callLimits
+	// Interval and disks with snapshot gauges per target
+	recorded map[metrics.Target]targetFreshness
+	// State of every target as of its last check
+	status map[metrics.Target]TargetStatus
+	// End and number of failures of the last watch cycle
+	lastCycle         time.Time
+	lastCycleFailures int
+	// Whether a watch cycle has listed the snapshots of the targets
+	discovered bool
 }
 
 type WatcherInterface interface {
-	Watch(sc *models.SnapshotConfigs)
-	CheckAndSnapDisks(disks []compute.Disk, retentionStart, lastAcceptedCreation int)
-	deleteSnapshot(s compute.Snapshot)
-	createSnapshot(d compute.Disk)
-	pollZonalOperation(operation, zone string)
-}
-
-func (w *Watcher) Watch(sc *models.SnapshotConfigs) {
-	for t := time.Tick(time.Second * time.Duration(w.WatchInterval)); ; <-t {
-
-		// Check Labels
-		for _, lConfig := range sc.Labels {
-			retentionStart := time.Now().Add(-time.Duration(lConfig.RetentionPeriodHours) * time.Hour)
-			lastAcceptedCreation := time.Now().Add(time.Duration(-lConfig.IntervalSeconds) * time.Second)
-
-			// Get disks
-			disks, err := w.GSC.GetDisksFromLabel(lConfig.Label)
-			if err != nil {
-				log.Error(err)
-				continue
-			}
-			w.CheckAndSnapDisks(disks, retentionStart, lastAcceptedCreation)
-		}
-		// Check Descriptions
-		for _, dConfig := range sc.Descriptions {
-			retentionStart := time.Now().Add(-time.Duration(dConfig.RetentionPeriodHours) * time.Hour)
-			lastAcceptedCreation := time.Now().Add(time.Duration(-dConfig.IntervalSeconds) * time.Second)
-
-			// Get disks
-			disks, err := w.GSC.GetDisksFromDescription(dConfig.Description)
-			if err != nil {
-				log.Error(err)
-				continue
-			}
-			w.CheckAndSnapDisks(disks, retentionStart, lastAcceptedCreation)
+	SetConfig(sc *models.SnapshotConfigs)
+	Watch(ctx context.Context)
+	RunOnce(ctx context.Context) error
+	Shutdown()
+	CheckAndSnapDisks(ctx context.Context, disks []compute.Disk, snaps snapshot.SnapshotIndex, owner string, retention Retention, lastAcceptedCreation time.Time)
+	deleteSnapshot(ctx context.Context, s compute.Snapshot)
+	createSnapshot(ctx context.Context, d compute.Disk, owner string)
+	pollZonalOperation(id int, operation, zone string)
+	pollRegionalOperation(id int, operation, region string)
+	pollGlobalOperation(id int, operation string)
+}
+
+// SetConfig replaces the snapshot configuration. It is safe to call while
+// watching, and takes effect from the next watch cycle.
+func (w *Watcher) SetConfig(sc *models.SnapshotConfigs) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	w.snapshotConfigs = sc
+}
+
+func (w *Watcher) config() *models.SnapshotConfigs {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.snapshotConfigs
+}
+
+// Watch runs watch cycles until ctx is done. Operations that are still being
+// polled are left running, see Shutdown.
+func (w *Watcher) Watch(ctx context.Context) {
+	ticker := time.NewTicker(time.Second * time.Duration(w.WatchInterval))
+	defer ticker.Stop()
+
+	for {
+		nextDue, _ := w.cycle(ctx)
+
+		// Wake up early if a scheduled snapshot is due before the next tick
+		var due <-chan time.Time
+		var timer *time.Timer
+		if !nextDue.IsZero() {
+			timer = time.NewTimer(time.Until(nextDue))
+			due = timer.C
+		}
+		select {
+		case <-ticker.C:
+		case <-due:
+		case <-ctx.Done():
+		}
+		if timer != nil {
+			timer.Stop()
+		}
+		if ctx.Err() != nil {
+			log.Info("Stopped watching")
+			return
 		}
 	}
 }
 
-func (w *Watcher) CheckAndSnapDisks(disks []compute.Disk, retentionStart, lastAcceptedCreation time.Time) {
-	for _, disk := range disks {
-		log.Debug("Checking disk: ", disk.Name)
+// Shutdown waits for the pending operations to finish, for at most the
+// shutdown grace period, and logs the ones left unresolved
+func (w *Watcher) Shutdown() {
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	if failed := w.drain(ctx); failed > 0 {
+		log.Warn(failed, " operation(s) failed or were left unresolved on shutdown")
+	}
+}
+
+// drain waits for the pending operations to finish and returns the number of
+// the ones that failed. Once ctx is done, it waits for at most the shutdown
+// grace period, then stops polling and logs the operations left unresolved.
+func (w *Watcher) drain(ctx context.Context) int {
+	done := make(chan int, 1)
+	go func() {
+		done <- w.ops.wait()
+	}()
 
-		// Get snapshots per disk created by the snapshotter
-		snaps, err := w.GSC.ListClientCreatedSnapshots(disk.SelfLink)
+	select {
+	case failed := <-done:
+		return failed
+	case <-ctx.Done():
+	}
+
+	if pending := len(w.ops.list()); pending > 0 {
+		log.Info("Waiting up to ", w.ShutdownGracePeriod, " for ", pending, " pending operation(s)")
+	}
+	select {
+	case failed := <-done:
+		return failed
+	case <-time.After(w.ShutdownGracePeriod):
+	}
+
+	for _, op := range w.ops.abandon() {
+		log.Warn(fmt.Sprintf("Unresolved %s operation: %s of disk: %s started at: %s", op.Action, op.Name, op.Disk, op.Started))
+	}
+	return <-done
+}
+
+// owner returns the default owner of the targets
+func (w *Watcher) owner() string {
+	if w.Owner == "" {
+		return snapshot.SnapshotterLabelValue
+	}
+	return w.Owner
+}
+
+// RunOnce runs a single watch cycle and waits for all the operations it
+// started to finish, or for the shutdown grace period once ctx is done. It
+// returns an error if anything failed.
+func (w *Watcher) RunOnce(ctx context.Context) error {
+	_, failures := w.cycle(ctx)
+	log.Info("Waiting for pending operations to finish")
+	failures += w.drain(ctx)
+	if failures > 0 {
+		return fmt.Errorf("%d failure(s) during the watch cycle", failures)
+	}
+	return nil
+}
+
+// cycle runs a watch cycle over all configured targets and returns the
+// earliest time a scheduled target will be due next, and the number of
+// failures
+func (w *Watcher) cycle(ctx context.Context) (nextDue time.Time, failures int) {
+	start := time.Now()
+	discovered := false
+	defer func() {
+		w.Metrics.ObserveWatchCycleDuration(time.Since(start))
+		w.mu.Lock()
+		w.lastCycle = time.Now()
+		w.lastCycleFailures = failures
+		w.discovered = w.discovered || discovered
+		w.mu.Unlock()
+	}()
+
+	targets := targetsFromConfig(w.config(), w.GSC, w.owner())
+	w.forgetTargets(targets)
+	if len(targets) == 0 {
+		log.Debug("No targets configured")
+		discovered = true
+		return time.Time{}, 0
+	}
+
+	// List the snapshots owned by any of the targets once per cycle
+	snaps, err := w.GSC.ListClientCreatedSnapshots(ctx, owners(targets))
+	if err != nil {
+		log.Error("Skipping watch cycle: ", err)
+		return time.Time{}, 1
+	}
+	discovered = true
+	return w.checkTargets(ctx, targets, snaps)
+}
+
+// checkTargets checks the disks of all targets and returns the earliest time
+// a scheduled target will be due next, and the number of failures
+func (w *Watcher) checkTargets(ctx context.Context, targets []target, snaps snapshot.SnapshotIndex) (time.Time, int) {
+	var earliest time.Time
+	failures := 0
+	for _, t := range targets {
+		if ctx.Err() != nil {
+			log.Info("Shutting down, skipping the remaining targets")
+			break
+		}
+		now := time.Now()
+		plans, nextDue, err := w.planTarget(ctx, t, snaps, now)
 		if err != nil {
-			log.Fatal(err)
+			log.Error("target ", t.name, ": ", err)
+			failures++
+			continue
+		}
+		w.recordFreshness(t, plans, now)
+		w.recordStatus(t, plans, nextDue, now)
+		if t.schedule != nil {
+			log.Debug("target ", t.name, " next scheduled snapshot at: ", nextDue)
+			if earliest.IsZero() || nextDue.Before(earliest) {
+				earliest = nextDue
+			}
 		}
+		failures += w.apply(ctx, plans)
+	}
+	return earliest, failures
+}
 
-		// Initialise values for delete list and snapshot flag
-		snapsToDelete := []compute.Snapshot{}
-		snapNeeded := true
+// CheckAndSnapDisks deletes the snapshots of the given disks that are not kept by the
+// retention and takes new ones where needed. Only the snapshots of the given owner,
+// looked up in the index, are considered, and new ones are labelled with it.
+func (w *Watcher) CheckAndSnapDisks(ctx context.Context, disks []compute.Disk, snaps snapshot.SnapshotIndex, owner string, retention Retention, lastAcceptedCreation time.Time) {
+	w.apply(ctx, planDisks(disks, snaps, owner, retention, lastAcceptedCreation))
+}
 
-		// Check timestamps of all snapshots to update the above vars
-		for _, snap := range snaps {
-			snapTime, err := time.Parse(GCPSnapshotTimestampLayout, snap.CreationTimestamp)
-			if err != nil {
-				log.Error("failed to parse timestamp:", err)
-				continue
-			}
+// apply deletes and creates snapshots according to the given plans and
+// returns the number of disks that failed. Disks are checked independently
+// by a pool of workers, so that a failing disk does not affect the others. In
+// dry run mode it only logs what it would do. No new work is started once ctx
+// is done.
+func (w *Watcher) apply(ctx context.Context, plans []DiskPlan) int {
+	workers := w.Workers
+	if workers < 1 {
+		workers = 1
+	}
 
-			// If created before retention start time we need to delete
-			if snapTime.Before(retentionStart) {
-				snapsToDelete = append(snapsToDelete, *snap)
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	failures := 0
+	jobs := make(chan DiskPlan)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for plan := range jobs {
+				// Plans that were queued before shutting down are skipped too
+				if ctx.Err() != nil {
+					continue
+				}
+				if !w.applyPlan(ctx, plan) {
+					mu.Lock()
+					failures++
+					mu.Unlock()
+				}
 			}
+		}()
+	}
+	for _, plan := range plans {
+		if ctx.Err() != nil {
+			break
+		}
+		jobs <- plan
+	}
+	close(jobs)
+	wg.Wait()
 
-			// If a snap was taken after last accepted creation time we do not need a new one
-			if snapTime.After(lastAcceptedCreation) {
-				snapNeeded = false
-			}
+	if ctx.Err() != nil {
+		log.Info("Shutting down, skipped the remaining disks")
+	}
+	return failures
+}
+
+// applyPlan checks a single disk and records the result. It returns false if
+// the check failed.
+func (w *Watcher) applyPlan(ctx context.Context, plan DiskPlan) bool {
+	log.Debug("Checking disk: ", plan.Disk.Name)
+
+	if w.DryRun {
+		for _, s := range plan.Delete {
+			log.Info("Dry run: would delete snapshot: ", s.Name, " of disk: ", plan.Disk.Name)
+		}
+		if plan.Create {
+			log.Info("Dry run: would create snapshot of disk: ", plan.Disk.Name)
 		}
+		return true
+	}
 
-		// Delete old snaps
-		for _, s := range snapsToDelete {
-			if err := w.deleteSnapshot(s); err != nil {
-				log.Error("error deleting snapshot: ", err)
-				w.Metrics.UpdateDeleteSnapshotStatus(disk.Name, false)
-			} else {
-				w.Metrics.UpdateDeleteSnapshotStatus(disk.Name, true)
-			}
+	if err := w.checkDisk(ctx, plan); err != nil {
+		log.Error("disk ", plan.Disk.Name, ": ", err)
+		w.Metrics.UpdateDiskCheckStatus(plan.Disk.Name, false)
+		return false
+	}
+	w.Metrics.UpdateDiskCheckStatus(plan.Disk.Name, true)
+	return true
+}
+
+// checkDisk deletes the expired snapshots of a disk and creates a new one if
+// needed. A snapshot is still created if deleting the expired ones failed. It
+// returns the first error.
+func (w *Watcher) checkDisk(ctx context.Context, plan DiskPlan) error {
+	disk := plan.Disk
+	var checkErr error
+
+	// Delete old snaps
+	for _, s := range plan.Delete {
+		err := w.startOperation(ctx, func() error { return w.deleteSnapshot(ctx, s) })
+		w.Metrics.UpdateDeleteSnapshotStatus(disk.Name, err == nil)
+		if err != nil && checkErr == nil {
+			checkErr = errors.Wrapf(err, "error deleting snapshot %s", s.Name)
 		}
+	}
 
-		// Take snapshot if needed
-		if snapNeeded {
-			if err := w.createSnapshot(disk); err != nil {
-				log.Error("error creating snapshot: ", err)
-				w.Metrics.UpdateCreateSnapshotStatus(disk.Name, false)
-			} else {
-				w.Metrics.UpdateCreateSnapshotStatus(disk.Name, true)
-			}
+	// Take snapshot if needed
+	if plan.Create {
+		err := w.startOperation(ctx, func() error { return w.createSnapshot(ctx, disk, plan.Owner) })
+		w.Metrics.UpdateCreateSnapshotStatus(disk.Name, err == nil)
+		if err != nil && checkErr == nil {
+			checkErr = errors.Wrap(err, "error creating snapshot")
 		}
+	}
+	return checkErr
+}
 
+// startOperation calls fn, which starts an operation, once a slot to poll the
+// operation is free and the call is allowed by the limits of the watcher. The
+// poll slot is reserved first, so that waiting for one does not hold a slot
+// of the in-flight calls. The slot is released if fn fails.
+func (w *Watcher) startOperation(ctx context.Context, fn func() error) error {
+	if err := w.ops.reserve(ctx, w.MaxPolledOperations); err != nil {
+		return err
+	}
+	err := w.limitCall(ctx, fn)
+	if err != nil {
+		w.ops.release()
 	}
+	return err
 }
 
-func (w *Watcher) deleteSnapshot(s compute.Snapshot) error {
+func (w *Watcher) deleteSnapshot(ctx context.Context, s compute.Snapshot) error {
 	log.Info("Attempting to delete snapshot: ", s.Name)
-	op, err := w.GSC.DeleteSnapshot(s.Name)
+	op, err := w.GSC.DeleteSnapshot(ctx, s.Name)
 	if err != nil {
 		return err
 	}
 
 	// Delete snapshot is a global operation!!!
-	go w.pollGlobalOperation(op)
+	id := w.ops.add(Operation{Name: op, Type: "global", Action: "delete", Disk: snapshot.FormatLinkString(s.SourceDisk), Started: time.Now()})
+	go w.pollGlobalOperation(id, op)
 
 	return nil
 }
 
-func (w *Watcher) createSnapshot(d compute.Disk) error {
+func (w *Watcher) createSnapshot(ctx context.Context, d compute.Disk, owner string) error {
 	log.Debug("Attempt to take snapshot of disk: ", d.Name)
-	op, err := w.GSC.CreateSnapshot(d.Name, d.Zone)
+
+	// Regional disks have a region instead of a zone
+	if d.Region != "" {
+		op, err := w.GSC.CreateRegionalSnapshot(ctx, d.Name, d.Region, owner)
+		if err != nil {
+			return err
+		}
+		log.Info(fmt.Sprintf("New snapshot of regional disk: %v operation: %v", d.Name, op))
+
+		// Create snapshot of a regional disk is a regional operation!!!
+		id := w.ops.add(Operation{Name: op, Type: "regional", Action: "create", Disk: d.Name, Started: time.Now()})
+		go w.pollRegionalOperation(id, op, d.Region)
+
+		return nil
+	}
+
+	op, err := w.GSC.CreateSnapshot(ctx, d.Name, d.Zone, owner)
 	if err != nil {
 		return err
 	}
 	log.Info(fmt.Sprintf("New snapshot of disk: %v operation: %v", d.Name, op))
 
 	// Create snapshot is a zonal operation!!!
-	go w.pollZonalOperation(op, d.Zone)
+	id := w.ops.add(Operation{Name: op, Type: "zonal", Action: "create", Disk: d.Name, Started: time.Now()})
+	go w.pollZonalOperation(id, op, d.Zone)
 
 	return nil
 }
 
-func (w *Watcher) pollZonalOperation(operation, zone string) {
+func (w *Watcher) pollZonalOperation(id int, operation, zone string) {
+	w.pollOperation(id, "zonal", operation, func(ctx context.Context) (string, error) {
+		return w.GSC.WaitZonalOperation(ctx, operation, zone)
+	})
+}
+
+func (w *Watcher) pollRegionalOperation(id int, operation, region string) {
+	w.pollOperation(id, "regional", operation, func(ctx context.Context) (string, error) {
+		return w.GSC.WaitRegionalOperation(ctx, operation, region)
+	})
+}
+
+func (w *Watcher) pollGlobalOperation(id int, operation string) {
+	w.pollOperation(id, "global", operation, func(ctx context.Context) (string, error) {
+		return w.GSC.WaitGlobalOperation(ctx, operation)
+	})
+}
+
+// pollOperation waits for an operation until it is done, fails or times out,
+// and records the result under the given operation type. The delay between
+// waits grows up to pollIntervalMax. Operations are polled independently of
+// the watch cycles, until they are abandoned.
+func (w *Watcher) pollOperation(id int, operationType, operation string, wait func(ctx context.Context) (string, error)) {
+	opsCtx := w.ops.context()
+	ctx := opsCtx
+	if w.OperationTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(opsCtx, w.OperationTimeout)
+		defer cancel()
+	}
+
+	interval := pollInterval
 	for {
-		status, err := w.GSC.GetZonalOperationStatus(operation, zone)
-		if err != nil {
-			log.Error("Operation failed: ", operation, err)
-			w.Metrics.UpdateOperationStatus("zonal", false)
+		status, err := wait(ctx)
+		if opsCtx.Err() != nil {
+			log.Warn("Stopped polling operation: ", operation)
+			w.ops.done(id, false)
 			break
 		}
-		if status == "DONE" {
-			log.Info("Operation succeeded: ", operation)
-			w.Metrics.UpdateOperationStatus("zonal", true)
+		if ctx.Err() != nil {
+			log.Error("Operation timed out after ", w.OperationTimeout, ": ", operation)
+			w.Metrics.UpdateOperationTimeoutCount(operationType)
+			w.ops.done(id, false)
 			break
 		}
-		time.Sleep(1 * time.Second)
-	}
-}
-
-func (w *Watcher) pollGlobalOperation(operation string) {
-	for {
-		status, err := w.GSC.GetGlobalOperationStatus(operation)
 		if err != nil {
 			log.Error("Operation failed: ", operation, err)
-			w.Metrics.UpdateOperationStatus("global", false)
+			w.observeOperation(id, operationType, false)
+			w.Metrics.UpdateOperationStatus(operationType, false)
+			w.ops.done(id, false)
 			break
 		}
 		if status == "DONE" {
 			log.Info("Operation succeeded: ", operation)
-			w.Metrics.UpdateOperationStatus("global", true)
+			w.observeOperation(id, operationType, true)
+			w.Metrics.UpdateOperationStatus(operationType, true)
+			w.ops.done(id, true)
 			break
 		}
-		time.Sleep(1 * time.Second)
+		select {
+		case <-time.After(interval):
+		case <-ctx.Done():
+		}
+		interval *= 2
+		if interval > pollIntervalMax {
+			interval = pollIntervalMax
+		}
+	}
+}
+
+// observeOperation records the duration of a finished operation
+func (w *Watcher) observeOperation(id int, operationType string, success bool) {
+	if op, ok := w.ops.get(id); ok {
+		w.Metrics.ObserveOperationDuration(operationType, op.Action, success, time.Since(op.Started))
 	}
 }
@@ -1,12 +1,15 @@
 package watch
 
 import (
+	"context"
 	"testing"
+	"time"
 
 	"github.com/golang/mock/gomock"
 	"github.com/pkg/errors"
 	"github.com/stretchr/testify/assert"
 	"github.com/utilitywarehouse/gcp-disk-snapshotter/metrics"
+	"github.com/utilitywarehouse/gcp-disk-snapshotter/models"
 	"github.com/utilitywarehouse/gcp-disk-snapshotter/snapshot"
 	compute "google.golang.org/api/compute/v1"
 )
@@ -36,10 +39,10 @@ func TestCreateSnapshot(t *testing.T) {
 	// Successful Run
 	gomock.InOrder(
 		expectCreateSnapshotAndReturnSuccessfully(mgsc, d.Name, d.Zone),
-		expectGetZonalOperationStatusAndWriteToChannel(mgsc, "op", d.Zone, op_res),
+		expectWaitZonalOperationAndWriteToChannel(mgsc, "op", d.Zone, op_res),
 		expectUpdateOperationStatus(metrics, "zonal", true),
 	)
-	err := watcher.createSnapshot(d)
+	err := watcher.createSnapshot(context.Background(), d, snapshot.SnapshotterLabelValue)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -50,7 +53,7 @@ func TestCreateSnapshot(t *testing.T) {
 
 	expectCreateSnapshotAndReturnError(mgsc, d.Name, d.Zone, testErr)
 
-	err = watcher.createSnapshot(d)
+	err = watcher.createSnapshot(context.Background(), d, snapshot.SnapshotterLabelValue)
 	if err == nil {
 		t.Fatal("No error returned!")
 	}
@@ -58,6 +61,44 @@ func TestCreateSnapshot(t *testing.T) {
 
 }
 
+func TestCreateRegionalSnapshot(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// Watcher with mocked GCPSnapClient interface
+	mgsc := snapshot.NewMockGCPSnapClientInterface(mockCtrl)
+	metrics := metrics.NewMockPrometheusInterface(mockCtrl)
+
+	watcher := &Watcher{
+		GSC:     mgsc,
+		Metrics: metrics,
+	}
+
+	// test regional compute disk
+	d := compute.Disk{
+		Name:   "test",
+		Region: "test-region",
+	}
+	// Channel used to wait for operation polling
+	op_res := make(chan bool)
+
+	gomock.InOrder(
+		mgsc.EXPECT().CreateRegionalSnapshot(gomock.Any(), d.Name, d.Region, snapshot.SnapshotterLabelValue).Times(1).Return("op", nil),
+		mgsc.EXPECT().WaitRegionalOperation(gomock.Any(), "op", d.Region).Times(1).Do(
+			func(ctx context.Context, operation, region string) {
+				op_res <- true
+			},
+		).Return("DONE", nil),
+		expectUpdateOperationStatus(metrics, "regional", true),
+	)
+	err := watcher.createSnapshot(context.Background(), d, snapshot.SnapshotterLabelValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	waitForOp(op_res)
+}
+
 func TestDeleteSnapshot(t *testing.T) {
 
 	mockCtrl := gomock.NewController(t)
@@ -80,10 +121,10 @@ func TestDeleteSnapshot(t *testing.T) {
 
 	gomock.InOrder(
 		expectDeleteSnapshotAndReturnSuccessfully(mgsc, s.Name),
-		expectGetGlobalOperationStatusAndWriteToChannel(mgsc, "op", op_res),
+		expectWaitGlobalOperationAndWriteToChannel(mgsc, "op", op_res),
 		expectUpdateOperationStatus(metrics, "global", true),
 	)
-	err := watcher.deleteSnapshot(s)
+	err := watcher.deleteSnapshot(context.Background(), s)
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -94,7 +135,7 @@ func TestDeleteSnapshot(t *testing.T) {
 
 	expectDeleteSnapshotAndReturnError(mgsc, s.Name, testErr)
 
-	err = watcher.deleteSnapshot(s)
+	err = watcher.deleteSnapshot(context.Background(), s)
 	if err == nil {
 		t.Fatal("No error returned!")
 	}
@@ -102,6 +143,230 @@ func TestDeleteSnapshot(t *testing.T) {
 
 }
 
+func TestCheckAndSnapDisks(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// Watcher with mocked GCPSnapClient interface
+	mgsc := snapshot.NewMockGCPSnapClientInterface(mockCtrl)
+	metrics := metrics.NewMockPrometheusInterface(mockCtrl)
+
+	watcher := &Watcher{
+		GSC:     mgsc,
+		Metrics: metrics,
+	}
+
+	now := time.Now()
+	disks := []compute.Disk{
+		{Name: "stale", Zone: "test", SelfLink: "link/stale"},
+		{Name: "fresh", Zone: "test", SelfLink: "link/fresh"},
+	}
+	owned := map[string]string{snapshot.SnapshotterLabel: snapshot.SnapshotterLabelValue}
+	other := map[string]string{snapshot.SnapshotterLabel: "other"}
+	// Snapshots of both disks, from the index of the cycle. Snapshots of other
+	// owners must be left alone.
+	snaps := snapshot.SnapshotIndex{
+		"link/stale": {
+			{Name: "stale-old", Labels: owned, CreationTimestamp: now.Add(-3 * time.Hour).Format(GCPSnapshotTimestampLayout)},
+			{Name: "stale-other-old", Labels: other, CreationTimestamp: now.Add(-3 * time.Hour).Format(GCPSnapshotTimestampLayout)},
+			{Name: "stale-other-new", Labels: other, CreationTimestamp: now.Add(-time.Minute).Format(GCPSnapshotTimestampLayout)},
+		},
+		"link/fresh": {
+			{Name: "fresh-new", Labels: owned, CreationTimestamp: now.Add(-time.Minute).Format(GCPSnapshotTimestampLayout)},
+		},
+	}
+
+	// Channels used to wait for operation polling
+	delete_res := make(chan bool)
+	create_res := make(chan bool)
+
+	// Only the stale disk needs its old snapshot deleted and a new one
+	gomock.InOrder(
+		expectDeleteSnapshotAndReturnSuccessfully(mgsc, "stale-old"),
+		metrics.EXPECT().UpdateDeleteSnapshotStatus("stale", true).Times(1),
+		expectCreateSnapshotAndReturnSuccessfully(mgsc, "stale", "test"),
+		metrics.EXPECT().UpdateCreateSnapshotStatus("stale", true).Times(1),
+		metrics.EXPECT().UpdateDiskCheckStatus("stale", true).Times(1),
+		metrics.EXPECT().UpdateDiskCheckStatus("fresh", true).Times(1),
+	)
+	mgsc.EXPECT().WaitGlobalOperation(gomock.Any(), "op").Times(1).Return("DONE", nil)
+	expectUpdateOperationStatusAndWriteToChannel(metrics, "global", true, delete_res)
+	mgsc.EXPECT().WaitZonalOperation(gomock.Any(), "op", "test").Times(1).Return("DONE", nil)
+	expectUpdateOperationStatusAndWriteToChannel(metrics, "zonal", true, create_res)
+
+	retention := NewRetention(now, 2, nil)
+	watcher.CheckAndSnapDisks(context.Background(), disks, snaps, snapshot.SnapshotterLabelValue, retention, now.Add(-time.Hour))
+	waitForOp(delete_res)
+	waitForOp(create_res)
+}
+
+func TestCheckAndSnapDisksIsolatesFailures(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// Watcher with mocked GCPSnapClient interface
+	mgsc := snapshot.NewMockGCPSnapClientInterface(mockCtrl)
+	metrics := metrics.NewMockPrometheusInterface(mockCtrl)
+
+	watcher := &Watcher{
+		GSC:     mgsc,
+		Metrics: metrics,
+	}
+	plans := []DiskPlan{
+		{Owner: snapshot.SnapshotterLabelValue, Disk: compute.Disk{Name: "bad", Zone: "test"}, Create: true},
+		{Owner: snapshot.SnapshotterLabelValue, Disk: compute.Disk{Name: "good", Zone: "test"}, Create: true},
+	}
+
+	// The bad disk fails, the good disk is still checked
+	testErr := errors.New("test error")
+	create_res := make(chan bool)
+	expectCreateSnapshotAndReturnError(mgsc, "bad", "test", testErr)
+	expectCreateSnapshotAndReturnSuccessfully(mgsc, "good", "test")
+	metrics.EXPECT().UpdateCreateSnapshotStatus("bad", false).Times(1)
+	metrics.EXPECT().UpdateDiskCheckStatus("bad", false).Times(1)
+	metrics.EXPECT().UpdateCreateSnapshotStatus("good", true).Times(1)
+	metrics.EXPECT().UpdateDiskCheckStatus("good", true).Times(1)
+	mgsc.EXPECT().WaitZonalOperation(gomock.Any(), "op", "test").Times(1).Return("DONE", nil)
+	expectUpdateOperationStatusAndWriteToChannel(metrics, "zonal", true, create_res)
+
+	failures := watcher.apply(context.Background(), plans)
+	assert.Equal(t, 1, failures)
+	waitForOp(create_res)
+}
+
+func TestRunOnce(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// Watcher with mocked GCPSnapClient interface
+	mgsc := snapshot.NewMockGCPSnapClientInterface(mockCtrl)
+	metrics := metrics.NewMockPrometheusInterface(mockCtrl)
+
+	watcher := &Watcher{
+		GSC:     mgsc,
+		Metrics: metrics,
+	}
+	label := &models.Label{Key: "name", Value: "test"}
+	watcher.SetConfig(&models.SnapshotConfigs{
+		Labels: []*models.LabelSnapshotConfig{
+			{Label: label, IntervalSeconds: 3600, RetentionPeriodHours: 24},
+		},
+	})
+	d := compute.Disk{Name: "test", Zone: "test", SelfLink: "link/test"}
+
+	// The create call succeeds but the operation fails
+	gomock.InOrder(
+		mgsc.EXPECT().ListClientCreatedSnapshots(gomock.Any(), []string{snapshot.SnapshotterLabelValue}).Times(1).Return(snapshot.SnapshotIndex{}, nil),
+		mgsc.EXPECT().GetDisksFromLabel(gomock.Any(), label).Times(1).Return([]compute.Disk{d}, nil),
+		expectCreateSnapshotAndReturnSuccessfully(mgsc, d.Name, d.Zone),
+		metrics.EXPECT().UpdateCreateSnapshotStatus(d.Name, true).Times(1),
+		metrics.EXPECT().UpdateDiskCheckStatus(d.Name, true).Times(1),
+	)
+	metrics.EXPECT().ObserveWatchCycleDuration(gomock.Any()).Times(1)
+	metrics.EXPECT().UpdateTargetInterval(testTarget, time.Hour).Times(1)
+	metrics.EXPECT().UpdateDiskSnapshotStats(testTarget, d.Name, d.Zone, time.Time{}, 0, int64(0)).Times(1)
+	mgsc.EXPECT().WaitZonalOperation(gomock.Any(), "op", d.Zone).Times(1).Return("", errors.New("test error"))
+	expectUpdateOperationStatus(metrics, "zonal", false)
+
+	assert.False(t, watcher.Ready())
+	err := watcher.RunOnce(context.Background())
+	assert.EqualError(t, err, "1 failure(s) during the watch cycle")
+
+	// The cycle itself had no failures, only its operation
+	assert.True(t, watcher.Ready())
+	_, failures := watcher.LastCycle()
+	assert.Equal(t, 0, failures)
+}
+
+func TestRunOnceNotReadyWithoutDiscovery(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// Watcher with mocked GCPSnapClient interface
+	mgsc := snapshot.NewMockGCPSnapClientInterface(mockCtrl)
+	metrics := metrics.NewMockPrometheusInterface(mockCtrl)
+
+	watcher := &Watcher{
+		GSC:     mgsc,
+		Metrics: metrics,
+	}
+	label := &models.Label{Key: "name", Value: "test"}
+	watcher.SetConfig(&models.SnapshotConfigs{
+		Labels: []*models.LabelSnapshotConfig{
+			{Label: label, IntervalSeconds: 3600, RetentionPeriodHours: 24},
+		},
+	})
+
+	// Listing the snapshots fails, so the cycle is skipped
+	mgsc.EXPECT().ListClientCreatedSnapshots(gomock.Any(), []string{snapshot.SnapshotterLabelValue}).Times(1).Return(nil, errors.New("test error"))
+	metrics.EXPECT().ObserveWatchCycleDuration(gomock.Any()).Times(1)
+
+	err := watcher.RunOnce(context.Background())
+	assert.Error(t, err)
+
+	// The cycle finished, but without discovery the watcher is not ready
+	last, failures := watcher.LastCycle()
+	assert.False(t, last.IsZero())
+	assert.Equal(t, 1, failures)
+	assert.False(t, watcher.Ready())
+}
+
+func TestRunOnceShutdown(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// Watcher with mocked GCPSnapClient interface
+	mgsc := snapshot.NewMockGCPSnapClientInterface(mockCtrl)
+	metrics := metrics.NewMockPrometheusInterface(mockCtrl)
+
+	watcher := &Watcher{
+		GSC:                 mgsc,
+		Metrics:             metrics,
+		ShutdownGracePeriod: 10 * time.Millisecond,
+	}
+	label := &models.Label{Key: "name", Value: "test"}
+	watcher.SetConfig(&models.SnapshotConfigs{
+		Labels: []*models.LabelSnapshotConfig{
+			{Label: label, IntervalSeconds: 3600, RetentionPeriodHours: 24},
+		},
+	})
+	d1 := compute.Disk{Name: "test1", Zone: "test", SelfLink: "link/test1"}
+	d2 := compute.Disk{Name: "test2", Zone: "test", SelfLink: "link/test2"}
+
+	// Shutting down while snapshotting the first disk: the second disk is
+	// skipped and the operation that never finishes is abandoned
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	gomock.InOrder(
+		mgsc.EXPECT().ListClientCreatedSnapshots(gomock.Any(), []string{snapshot.SnapshotterLabelValue}).Times(1).Return(snapshot.SnapshotIndex{}, nil),
+		mgsc.EXPECT().GetDisksFromLabel(gomock.Any(), label).Times(1).Return([]compute.Disk{d1, d2}, nil),
+		expectCreateSnapshotAndReturnSuccessfully(mgsc, d1.Name, d1.Zone).Do(
+			func(ctx context.Context, name, zone, owner string) {
+				cancel()
+			},
+		),
+		metrics.EXPECT().UpdateCreateSnapshotStatus(d1.Name, true).Times(1),
+		metrics.EXPECT().UpdateDiskCheckStatus(d1.Name, true).Times(1),
+	)
+	metrics.EXPECT().ObserveWatchCycleDuration(gomock.Any()).Times(1)
+	metrics.EXPECT().UpdateTargetInterval(testTarget, time.Hour).Times(1)
+	metrics.EXPECT().UpdateDiskSnapshotStats(testTarget, d1.Name, d1.Zone, time.Time{}, 0, int64(0)).Times(1)
+	metrics.EXPECT().UpdateDiskSnapshotStats(testTarget, d2.Name, d2.Zone, time.Time{}, 0, int64(0)).Times(1)
+	mgsc.EXPECT().WaitZonalOperation(gomock.Any(), "op", d1.Zone).AnyTimes().Return("RUNNING", nil)
+
+	err := watcher.RunOnce(ctx)
+	assert.EqualError(t, err, "1 failure(s) during the watch cycle")
+}
+
+// testTarget identifies the target of the label name=test, the only one
+// configured by the tests
+var testTarget = metrics.Target{Name: "label:name=test", Owner: snapshot.SnapshotterLabelValue, Config: "Labels[0]"}
+
 func waitForOp(op_res chan bool) {
 	select {
 	case <-op_res:
@@ -110,37 +375,109 @@ func waitForOp(op_res chan bool) {
 }
 
 func expectCreateSnapshotAndReturnSuccessfully(gsc *snapshot.MockGCPSnapClientInterface, name, zone string) *gomock.Call {
-	return gsc.EXPECT().CreateSnapshot(name, zone).Times(1).Return("op", nil)
+	return gsc.EXPECT().CreateSnapshot(gomock.Any(), name, zone, snapshot.SnapshotterLabelValue).Times(1).Return("op", nil)
 }
 
 func expectCreateSnapshotAndReturnError(gsc *snapshot.MockGCPSnapClientInterface, name, zone string, err error) *gomock.Call {
-	return gsc.EXPECT().CreateSnapshot(name, zone).Times(1).Return("op", err)
+	return gsc.EXPECT().CreateSnapshot(gomock.Any(), name, zone, snapshot.SnapshotterLabelValue).Times(1).Return("op", err)
 }
 
 func expectDeleteSnapshotAndReturnSuccessfully(gsc *snapshot.MockGCPSnapClientInterface, name string) *gomock.Call {
-	return gsc.EXPECT().DeleteSnapshot(name).Times(1).Return("op", nil)
+	return gsc.EXPECT().DeleteSnapshot(gomock.Any(), name).Times(1).Return("op", nil)
 }
 
 func expectDeleteSnapshotAndReturnError(gsc *snapshot.MockGCPSnapClientInterface, name string, err error) *gomock.Call {
-	return gsc.EXPECT().DeleteSnapshot(name).Times(1).Return("op", err)
+	return gsc.EXPECT().DeleteSnapshot(gomock.Any(), name).Times(1).Return("op", err)
 }
 
-func expectGetZonalOperationStatusAndWriteToChannel(gsc *snapshot.MockGCPSnapClientInterface, operation, zone string, op_ch chan bool) *gomock.Call {
-	return gsc.EXPECT().GetZonalOperationStatus(operation, zone).Times(1).Do(
-		func(operation, zone string) {
+func expectWaitZonalOperationAndWriteToChannel(gsc *snapshot.MockGCPSnapClientInterface, operation, zone string, op_ch chan bool) *gomock.Call {
+	return gsc.EXPECT().WaitZonalOperation(gomock.Any(), operation, zone).Times(1).Do(
+		func(ctx context.Context, operation, zone string) {
 			op_ch <- true
 		},
 	).Return("DONE", nil)
 }
 
-func expectGetGlobalOperationStatusAndWriteToChannel(gsc *snapshot.MockGCPSnapClientInterface, operation string, op_ch chan bool) *gomock.Call {
-	return gsc.EXPECT().GetGlobalOperationStatus(operation).Times(1).Do(
-		func(operation string) {
+func expectWaitGlobalOperationAndWriteToChannel(gsc *snapshot.MockGCPSnapClientInterface, operation string, op_ch chan bool) *gomock.Call {
+	return gsc.EXPECT().WaitGlobalOperation(gomock.Any(), operation).Times(1).Do(
+		func(ctx context.Context, operation string) {
 			op_ch <- true
 		},
 	).Return("DONE", nil)
 }
 
 func expectUpdateOperationStatus(m *metrics.MockPrometheusInterface, operation_type string, success bool) *gomock.Call {
+	m.EXPECT().ObserveOperationDuration(operation_type, gomock.Any(), success, gomock.Any()).Times(1)
 	return m.EXPECT().UpdateOperationStatus(operation_type, success).Times(1).Return()
 }
+
+func expectUpdateOperationStatusAndWriteToChannel(m *metrics.MockPrometheusInterface, operation_type string, success bool, op_ch chan bool) *gomock.Call {
+	m.EXPECT().ObserveOperationDuration(operation_type, gomock.Any(), success, gomock.Any()).Times(1)
+	return m.EXPECT().UpdateOperationStatus(operation_type, success).Times(1).Do(
+		func(operation_type string, success bool) {
+			op_ch <- true
+		},
+	).Return()
+}
+
+func TestPollOperationTimeout(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// Watcher with mocked GCPSnapClient interface
+	mgsc := snapshot.NewMockGCPSnapClientInterface(mockCtrl)
+	metrics := metrics.NewMockPrometheusInterface(mockCtrl)
+
+	watcher := &Watcher{
+		GSC:              mgsc,
+		Metrics:          metrics,
+		OperationTimeout: 50 * time.Millisecond,
+	}
+	defer func(interval time.Duration) { pollInterval = interval }(pollInterval)
+	pollInterval = time.Millisecond
+
+	d := compute.Disk{Name: "test", Zone: "test"}
+
+	// The operation never finishes
+	expectCreateSnapshotAndReturnSuccessfully(mgsc, d.Name, d.Zone)
+	mgsc.EXPECT().WaitZonalOperation(gomock.Any(), "op", d.Zone).MinTimes(1).Return("RUNNING", nil)
+	metrics.EXPECT().UpdateOperationTimeoutCount("zonal").Times(1)
+
+	err := watcher.createSnapshot(context.Background(), d, snapshot.SnapshotterLabelValue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	assert.Equal(t, 1, watcher.ops.wait())
+}
+
+func TestApplyWorkers(t *testing.T) {
+
+	mockCtrl := gomock.NewController(t)
+	defer mockCtrl.Finish()
+
+	// Watcher with mocked GCPSnapClient interface
+	mgsc := snapshot.NewMockGCPSnapClientInterface(mockCtrl)
+	metrics := metrics.NewMockPrometheusInterface(mockCtrl)
+
+	watcher := &Watcher{
+		GSC:     mgsc,
+		Metrics: metrics,
+		Workers: 4,
+	}
+
+	// Every disk is checked once, one of them fails
+	plans := []DiskPlan{}
+	for _, name := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		plans = append(plans, DiskPlan{Disk: compute.Disk{Name: name}})
+		if name != "a" {
+			metrics.EXPECT().UpdateDiskCheckStatus(name, true).Times(1)
+		}
+	}
+	plans[0].Delete = []compute.Snapshot{{Name: "snap"}}
+	expectDeleteSnapshotAndReturnError(mgsc, "snap", errors.New("test error"))
+	metrics.EXPECT().UpdateDeleteSnapshotStatus("a", false).Times(1)
+	metrics.EXPECT().UpdateDiskCheckStatus("a", false).Times(1)
+
+	assert.Equal(t, 1, watcher.apply(context.Background(), plans))
+}